@@ -0,0 +1,303 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateApi = flag.Bool("update-api", false,
+	"write the current API surface to testdata/api")
+
+// apiPackages are the packages whose exported API is guarded against
+// incompatible changes.
+var apiPackages = []string{".", "faultinject", "outbox", "vcr", "zerokitotel",
+	"zerokittest"}
+
+// TestApiCompatibility compares the exported API of the packages with the
+// one recorded in testdata/api. Removed or changed declarations break
+// consumers and require a new major version; added ones only need the
+// recorded API to be updated with go test -run TestApiCompatibility
+// -update-api.
+func TestApiCompatibility(t *testing.T) {
+	for _, dir := range apiPackages {
+		name := filepath.Base(dir)
+		if dir == "." {
+			name = "zerokit"
+		}
+		t.Run(name, func(t *testing.T) {
+			api, undocumented := apiSurface(t, dir)
+			for _, decl := range undocumented {
+				t.Errorf("undocumented: %s", decl)
+			}
+
+			golden := filepath.Join("testdata", "api", name+".txt")
+			if *updateApi {
+				content := strings.Join(api, "\n") + "\n"
+				if err := ioutil.WriteFile(golden, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			content, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+
+			current := make(map[string]bool, len(api))
+			for _, decl := range api {
+				current[decl] = true
+			}
+			for _, decl := range recorded {
+				if !current[decl] {
+					t.Errorf("incompatible: removed or changed %s", decl)
+				}
+				delete(current, decl)
+			}
+			for _, decl := range api {
+				if current[decl] {
+					t.Errorf("added %s, update the recorded API", decl)
+				}
+			}
+		})
+	}
+}
+
+// apiSurface returns the exported declarations of the package in dir, one
+// per line and sorted, and those of them lacking a doc comment. Parameter
+// names are left out, as renaming them is compatible.
+func apiSurface(t *testing.T, dir string) (api, undocumented []string) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(decl string, doc *ast.CommentGroup) {
+		api = append(api, decl)
+		if doc == nil && !implementsStandard(decl) {
+			undocumented = append(undocumented, decl)
+		}
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if !decl.Name.IsExported() || !exportedReceiver(decl.Recv) {
+						continue
+					}
+					add(funcSignature(fset, decl), decl.Doc)
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						doc := decl.Doc
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							if spec.Doc != nil {
+								doc = spec.Doc
+							}
+							if spec.Name.IsExported() {
+								add(typeDeclaration(fset, spec), doc)
+							}
+						case *ast.ValueSpec:
+							if spec.Doc != nil || spec.Comment != nil {
+								doc = spec.Doc
+								if doc == nil {
+									doc = spec.Comment
+								}
+							}
+							for i, name := range spec.Names {
+								if !name.IsExported() {
+									continue
+								}
+								line := decl.Tok.String() + " " + name.Name
+								if spec.Type != nil {
+									line += " " + render(fset, spec.Type)
+								}
+								if decl.Tok == token.CONST && i < len(spec.Values) {
+									line += " = " + render(fset, spec.Values[i])
+								}
+								add(line, doc)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(api)
+	sort.Strings(undocumented)
+	return api, undocumented
+}
+
+// implementsStandard reports whether the method implements an interface of
+// the standard library, which documents it already.
+func implementsStandard(decl string) bool {
+	for _, method := range []string{") Error() string", ") String() string",
+		") Unwrap() error"} {
+		if strings.HasSuffix(decl, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func exportedReceiver(recv *ast.FieldList) bool {
+	if recv == nil {
+		return true
+	}
+	typ := recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if index, ok := typ.(*ast.IndexExpr); ok {
+		typ = index.X
+	}
+	ident, ok := typ.(*ast.Ident)
+	return ok && ident.IsExported()
+}
+
+func funcSignature(fset *token.FileSet, decl *ast.FuncDecl) string {
+	var b strings.Builder
+	b.WriteString("func ")
+	if decl.Recv != nil {
+		b.WriteString("(" + render(fset, decl.Recv.List[0].Type) + ") ")
+	}
+	b.WriteString(decl.Name.Name)
+	b.WriteString(strings.TrimPrefix(render(fset, unnamed(decl.Type)), "func"))
+	return b.String()
+}
+
+// unnamed returns a copy of the function type without parameter names.
+func unnamed(fn *ast.FuncType) *ast.FuncType {
+	strip := func(fields *ast.FieldList) *ast.FieldList {
+		if fields == nil {
+			return nil
+		}
+		stripped := &ast.FieldList{}
+		for _, field := range fields.List {
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				stripped.List = append(stripped.List, &ast.Field{Type: field.Type})
+			}
+		}
+		return stripped
+	}
+	return &ast.FuncType{
+		TypeParams: fn.TypeParams,
+		Params:     strip(fn.Params),
+		Results:    strip(fn.Results),
+	}
+}
+
+// typeDeclaration renders the type with its exported fields and methods only.
+func typeDeclaration(fset *token.FileSet, spec *ast.TypeSpec) string {
+	typ := spec.Type
+	switch t := typ.(type) {
+	case *ast.StructType:
+		typ = &ast.StructType{Fields: exportedFields(t.Fields)}
+	case *ast.InterfaceType:
+		methods := exportedFields(t.Methods)
+		for i, method := range methods.List {
+			if fn, ok := method.Type.(*ast.FuncType); ok {
+				methods.List[i] = &ast.Field{Names: method.Names, Type: unnamed(fn)}
+			}
+		}
+		typ = &ast.InterfaceType{Methods: methods}
+	case *ast.FuncType:
+		typ = unnamed(t)
+	}
+	assign := " "
+	if spec.Assign.IsValid() {
+		assign = " = "
+	}
+	return "type " + spec.Name.Name + assign + render(fset, typ)
+}
+
+func exportedFields(fields *ast.FieldList) *ast.FieldList {
+	exported := &ast.FieldList{}
+	for _, field := range fields.List {
+		var names []*ast.Ident
+		for _, name := range field.Names {
+			if name.IsExported() {
+				names = append(names, name)
+			}
+		}
+		if len(field.Names) > 0 && len(names) == 0 {
+			continue
+		}
+		if len(field.Names) == 0 && !embeddedExported(field.Type) {
+			continue
+		}
+		exported.List = append(exported.List,
+			&ast.Field{Names: names, Type: field.Type, Tag: field.Tag})
+	}
+	return exported
+}
+
+func embeddedExported(typ ast.Expr) bool {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.Ident:
+		return t.IsExported()
+	case *ast.SelectorExpr:
+		return true
+	}
+	return false
+}
+
+// render prints the node on a single line.
+func render(fset *token.FileSet, node ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), node); err != nil {
+		return err.Error()
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
@@ -0,0 +1,335 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The outcomes of an audited operation.
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEvent records a mutating admin operation performed by the client.
+type AuditEvent struct {
+	Operation string `json:"operation"`
+	// Targets identifies the objects affected by the operation, e.g. the
+	// TresorId of an approved tresor or the UserId of a registration.
+	Targets     map[string]string `json:"targets"`
+	AdminUserId string            `json:"adminUserId"`
+	Time        time.Time         `json:"time"`
+	Outcome     string            `json:"outcome"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// AuditSink keeps the record of the admin operations. Record is called after
+// every mutating call, whether it succeeded or not.
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// AuditError is returned by a mutating call whose event could not be
+// recorded by the AuditSink. Err is nil if the operation itself succeeded,
+// in which case its result is returned along with the AuditError.
+type AuditError struct {
+	Event     AuditEvent
+	Err       error
+	RecordErr error
+}
+
+func (e *AuditError) Error() string {
+	msg := fmt.Sprintf("cannot record audit event of %s: %v",
+		e.Event.Operation, e.RecordErr)
+	if e.Err != nil {
+		msg += fmt.Sprintf(" (operation failed: %v)", e.Err)
+	}
+	return msg
+}
+
+func (e *AuditError) Unwrap() error {
+	return e.Err
+}
+
+// WithAuditSink sets the sink recording the mutating admin operations.
+func WithAuditSink(sink AuditSink) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.auditSink = sink
+	}
+}
+
+// audit records the outcome of a mutating operation and returns the error to
+// be reported to the caller.
+func (c *ZeroKitAdminApiClient) audit(ctx context.Context, operation string,
+	targets map[string]string, err error) error {
+	if c.auditSink == nil {
+		return err
+	}
+	event := AuditEvent{
+		Operation:   operation,
+		Targets:     targets,
+		AdminUserId: c.adminUserId,
+		Time:        time.Now().UTC(),
+		Outcome:     AuditOutcomeSuccess,
+	}
+	if err != nil {
+		event.Outcome = AuditOutcomeFailure
+		event.Error = err.Error()
+	}
+	if recordErr := c.auditSink.Record(ctx, event); recordErr != nil {
+		return &AuditError{Event: event, Err: err, RecordErr: recordErr}
+	}
+	return err
+}
+
+// ErrAuditLogTampered is returned if the hash chain of an audit log is broken.
+var ErrAuditLogTampered = errors.New("audit log has been tampered with")
+
+// genesisHash is the previous hash of the first record of an audit log.
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// auditRecord is a line of the audit log. The hash covers the exact bytes of
+// the entry, which links to the previous record by its hash.
+type auditRecord struct {
+	Hash  string          `json:"hash"`
+	Entry json.RawMessage `json:"entry"`
+}
+
+type auditEntry struct {
+	Seq      uint64     `json:"seq"`
+	PrevHash string     `json:"prevHash"`
+	Event    AuditEvent `json:"event"`
+}
+
+func hashAuditEntry(entry []byte) string {
+	sum := sha256.Sum256(entry)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLogHead identifies the last record of an audit log.
+type AuditLogHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// FileAuditSink appends the audit events to a hash-chained JSONL file. Every
+// record contains the hash of its predecessor, so that removed, reordered or
+// modified records are detected by VerifyAuditLog. Records cut off the end
+// of the log leave a valid chain, though. To detect them, the Head of the
+// log has to be kept outside of it, e.g. in a database or a remote log, and
+// be checked by VerifyAuditLogHead.
+type FileAuditSink struct {
+	mu       sync.Mutex
+	f        auditFile
+	size     int64
+	seq      uint64
+	prevHash string
+}
+
+// auditFile is the log file written by a FileAuditSink, i.e. an *os.File.
+type auditFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenFileAuditSink opens the audit log at path for appending, creating it if
+// necessary. The existing records are verified before new ones are appended.
+// A partial last line, left by a crash while a record was written, is
+// removed, as the Record call writing it has failed.
+func OpenFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	size, seq, prevHash, err := openAuditLog(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileAuditSink{f: f, size: size, seq: seq, prevHash: prevHash}, nil
+}
+
+// openAuditLog returns the size of the verified log along with the sequence
+// number and hash of its last record.
+func openAuditLog(f *os.File) (int64, uint64, string, error) {
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if complete := bytes.LastIndexByte(content, '\n') + 1; complete < len(content) {
+		if err := f.Truncate(int64(complete)); err != nil {
+			return 0, 0, "", err
+		}
+		content = content[:complete]
+	}
+	seq, prevHash, err := verifyAuditLog(bytes.NewReader(content), nil)
+	return int64(len(content)), seq, prevHash, err
+}
+
+// Record appends the event to the log and syncs it to disk.
+func (s *FileAuditSink) Record(_ context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := json.Marshal(auditEntry{
+		Seq:      s.seq + 1,
+		PrevHash: s.prevHash,
+		Event:    event,
+	})
+	if err != nil {
+		return err
+	}
+	hash := hashAuditEntry(entry)
+	line, err := json.Marshal(auditRecord{Hash: hash, Entry: entry})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.f.Write(line); err != nil {
+		return s.discard(err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return s.discard(err)
+	}
+	s.size += int64(len(line))
+	s.seq++
+	s.prevHash = hash
+	return nil
+}
+
+// discard removes the partially written record after a failed write, so
+// that the next record does not continue its line.
+func (s *FileAuditSink) discard(err error) error {
+	if truncErr := s.f.Truncate(s.size); truncErr != nil {
+		return fmt.Errorf("%v (cannot remove the partial record: %v)", err, truncErr)
+	}
+	return err
+}
+
+// Head returns the last record written to the log.
+func (s *FileAuditSink) Head() AuditLogHead {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return AuditLogHead{Seq: s.seq, Hash: s.prevHash}
+}
+
+// Close closes the log file.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// VerifyAuditLog checks the hash chain of the audit log read from r. It
+// returns an error wrapping ErrAuditLogTampered if a record has been
+// modified, removed or reordered.
+func VerifyAuditLog(r io.Reader) error {
+	_, _, err := verifyAuditLog(r, nil)
+	return err
+}
+
+// VerifyAuditLogHead is like VerifyAuditLog, but also checks that the log
+// contains the record identified by head, which has been taken from
+// FileAuditSink.Head earlier, so that records cut off the end are detected.
+func VerifyAuditLogHead(r io.Reader, head AuditLogHead) error {
+	_, _, err := verifyAuditLog(r, &head)
+	return err
+}
+
+// VerifyAuditLogFile is like VerifyAuditLog, but reads the audit log from the
+// file at path.
+func VerifyAuditLogFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return VerifyAuditLog(f)
+}
+
+// verifyAuditLog returns the sequence number and hash of the last record. If
+// head is given, the log must contain it.
+func verifyAuditLog(r io.Reader, head *AuditLogHead) (uint64, string, error) {
+	seq, prevHash := uint64(0), genesisHash
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return 0, "", fmt.Errorf("%w: line %d is malformed: %v",
+				ErrAuditLogTampered, line, err)
+		}
+		if hashAuditEntry(record.Entry) != record.Hash {
+			return 0, "", fmt.Errorf("%w: line %d does not match its hash",
+				ErrAuditLogTampered, line)
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(record.Entry, &entry); err != nil {
+			return 0, "", fmt.Errorf("%w: line %d is malformed: %v",
+				ErrAuditLogTampered, line, err)
+		}
+		if entry.Seq != seq+1 || entry.PrevHash != prevHash {
+			return 0, "", fmt.Errorf("%w: line %d does not follow its predecessor",
+				ErrAuditLogTampered, line)
+		}
+		if head != nil && entry.Seq == head.Seq && record.Hash != head.Hash {
+			return 0, "", fmt.Errorf("%w: line %d does not match the head",
+				ErrAuditLogTampered, line)
+		}
+		seq, prevHash = entry.Seq, record.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, "", err
+	}
+	if head != nil && seq < head.Seq {
+		return 0, "", fmt.Errorf("%w: log ends before its head %d",
+			ErrAuditLogTampered, head.Seq)
+	}
+	return seq, prevHash, nil
+}
@@ -0,0 +1,287 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type memoryAuditSink struct {
+	events []AuditEvent
+	err    error
+}
+
+func (s *memoryAuditSink) Record(_ context.Context, event AuditEvent) error {
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func TestAuditMutatingCalls(t *testing.T) {
+	sink := &memoryAuditSink{}
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case ApproveTresorCreationPath:
+			return response(http.StatusForbidden, `{"ErrorCode":"Forbidden"}`), nil
+		case InitiateUserRegistrationPath:
+			return response(http.StatusOK,
+				`{"RegSessionId":"session","RegSessionVerifier":"v","UserId":"zk"}`), nil
+		}
+		return response(http.StatusOK, ""), nil
+	}, WithAuditSink(sink))
+
+	c.ListTresorMembers("xyz")
+	c.InitUserRegistration()
+	c.ApproveTresorCreation("xyz")
+	c.ValidateUserRegistration("zk", "session", "verifier", "validation")
+
+	expected := []AuditEvent{
+		{Operation: "InitUserRegistration", Outcome: AuditOutcomeSuccess,
+			Targets: map[string]string{"UserId": "zk", "RegSessionId": "session"}},
+		{Operation: "ApproveTresorCreation", Outcome: AuditOutcomeFailure,
+			Targets: map[string]string{"TresorId": "xyz"}},
+		{Operation: "ValidateUserRegistration", Outcome: AuditOutcomeSuccess,
+			Targets: map[string]string{"UserId": "zk", "RegSessionId": "session"}},
+	}
+	if len(sink.events) != len(expected) {
+		t.Fatalf("number of audit events = %d, want = %d",
+			len(sink.events), len(expected))
+	}
+	for i, e := range sink.events {
+		if e.Operation != expected[i].Operation || e.Outcome != expected[i].Outcome {
+			t.Errorf("event = %s %s, want = %s %s", e.Operation, e.Outcome,
+				expected[i].Operation, expected[i].Outcome)
+		}
+		for k, v := range expected[i].Targets {
+			if e.Targets[k] != v {
+				t.Errorf("%s target %s = %s, want = %s", e.Operation, k, e.Targets[k], v)
+			}
+		}
+		if e.AdminUserId != AdminUserId || e.Time.IsZero() {
+			t.Errorf("event %+v lacks admin user or timestamp", e)
+		}
+	}
+	if sink.events[1].Error == "" {
+		t.Error("failed operation must record its error")
+	}
+}
+
+func TestAuditRecordFailure(t *testing.T) {
+	sink := &memoryAuditSink{err: errors.New("disk full")}
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, ""), nil
+	}, WithAuditSink(sink))
+
+	err := c.ApproveTresorCreation("xyz")
+	auditErr, ok := err.(*AuditError)
+	if !ok {
+		t.Fatalf("err = %v, want *AuditError", err)
+	}
+	if auditErr.Err != nil || auditErr.RecordErr != sink.err {
+		t.Errorf("unexpected audit error %+v", auditErr)
+	}
+}
+
+func tempAuditLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "zerokit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "audit.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func writeAuditEvents(t *testing.T, path string, operations ...string) {
+	sink, err := OpenFileAuditSink(path)
+	if err != nil {
+		t.Fatalf("cannot open audit log: %v", err)
+	}
+	defer sink.Close()
+	for _, op := range operations {
+		err := sink.Record(context.Background(), AuditEvent{
+			Operation: op,
+			Targets:   map[string]string{"TresorId": "xyz"},
+			Outcome:   AuditOutcomeSuccess,
+		})
+		if err != nil {
+			t.Fatalf("cannot record audit event: %v", err)
+		}
+	}
+}
+
+func TestFileAuditSinkChain(t *testing.T) {
+	path, cleanup := tempAuditLog(t)
+	defer cleanup()
+
+	writeAuditEvents(t, path, "ApproveTresorCreation", "InitUserRegistration")
+	// reopening continues the existing chain
+	writeAuditEvents(t, path, "ValidateUserRegistration")
+
+	if err := VerifyAuditLogFile(path); err != nil {
+		t.Errorf("audit log must verify, was = %v", err)
+	}
+	content, _ := ioutil.ReadFile(path)
+	if n := bytes.Count(content, []byte("\n")); n != 3 {
+		t.Errorf("number of records = %d, want = %d", n, 3)
+	}
+}
+
+func TestVerifyAuditLogDetectsTampering(t *testing.T) {
+	path, cleanup := tempAuditLog(t)
+	defer cleanup()
+
+	writeAuditEvents(t, path, "ApproveTresorCreation", "InitUserRegistration",
+		"ValidateUserRegistration")
+	content, _ := ioutil.ReadFile(path)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(content), "\n"), "\n")
+
+	tampered := map[string]string{
+		"modified": strings.Replace(string(content), "InitUserRegistration",
+			"ListTresorMembers", 1),
+		"removed":   lines[0] + lines[2],
+		"truncated": lines[1] + lines[2],
+		"reordered": lines[1] + "\n" + lines[0] + lines[2],
+	}
+	for name, log := range tampered {
+		err := VerifyAuditLog(strings.NewReader(log))
+		if !errors.Is(err, ErrAuditLogTampered) {
+			t.Errorf("%s audit log: err = %v, want = %v",
+				name, err, ErrAuditLogTampered)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte(tampered["modified"]), 0600)
+	if _, err := OpenFileAuditSink(path); !errors.Is(err, ErrAuditLogTampered) {
+		t.Errorf("err = %v, want = %v", err, ErrAuditLogTampered)
+	}
+}
+
+func TestVerifyAuditLogHeadDetectsTruncation(t *testing.T) {
+	path, cleanup := tempAuditLog(t)
+	defer cleanup()
+
+	writeAuditEvents(t, path, "ApproveTresorCreation", "InitUserRegistration")
+	sink, err := OpenFileAuditSink(path)
+	if err != nil {
+		t.Fatalf("cannot open audit log: %v", err)
+	}
+	head := sink.Head()
+	sink.Close()
+	if head.Seq != 2 {
+		t.Errorf("head seq = %d, want = %d", head.Seq, 2)
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if err := VerifyAuditLogHead(bytes.NewReader(content), head); err != nil {
+		t.Errorf("audit log must verify, was = %v", err)
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	err = VerifyAuditLogHead(strings.NewReader(lines[0]), head)
+	if !errors.Is(err, ErrAuditLogTampered) {
+		t.Errorf("truncated audit log: err = %v, want = %v", err, ErrAuditLogTampered)
+	}
+
+	// a log continued after the head was taken verifies as well
+	writeAuditEvents(t, path, "ValidateUserRegistration")
+	content, _ = ioutil.ReadFile(path)
+	if err := VerifyAuditLogHead(bytes.NewReader(content), head); err != nil {
+		t.Errorf("continued audit log must verify, was = %v", err)
+	}
+}
+
+func TestOpenFileAuditSinkRemovesTornRecord(t *testing.T) {
+	path, cleanup := tempAuditLog(t)
+	defer cleanup()
+
+	writeAuditEvents(t, path, "ApproveTresorCreation", "InitUserRegistration")
+	content, _ := ioutil.ReadFile(path)
+	torn := append(content, content[:len(content)/3]...)
+	ioutil.WriteFile(path, torn, 0600)
+
+	writeAuditEvents(t, path, "ValidateUserRegistration")
+	if err := VerifyAuditLogFile(path); err != nil {
+		t.Errorf("audit log must verify, was = %v", err)
+	}
+	content, _ = ioutil.ReadFile(path)
+	if n := bytes.Count(content, []byte("\n")); n != 3 {
+		t.Errorf("number of records = %d, want = %d", n, 3)
+	}
+}
+
+// failingAuditFile writes half of a record before failing.
+type failingAuditFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *failingAuditFile) Write(p []byte) (int, error) {
+	if f.fail {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errors.New("no space left on device")
+	}
+	return f.File.Write(p)
+}
+
+func TestFileAuditSinkRemovesPartialRecordOnWriteError(t *testing.T) {
+	path, cleanup := tempAuditLog(t)
+	defer cleanup()
+
+	sink, err := OpenFileAuditSink(path)
+	if err != nil {
+		t.Fatalf("cannot open audit log: %v", err)
+	}
+	defer sink.Close()
+	f := &failingAuditFile{File: sink.f.(*os.File)}
+	sink.f = f
+
+	event := AuditEvent{Operation: "ApproveTresorCreation", Outcome: AuditOutcomeSuccess}
+	if err := sink.Record(context.Background(), event); err != nil {
+		t.Fatalf("cannot record audit event: %v", err)
+	}
+	f.fail = true
+	if err := sink.Record(context.Background(), event); err == nil {
+		t.Fatal("failed write must be reported")
+	}
+	f.fail = false
+	if err := sink.Record(context.Background(), event); err != nil {
+		t.Fatalf("cannot record audit event: %v", err)
+	}
+
+	if err := VerifyAuditLogFile(path); err != nil {
+		t.Errorf("audit log must verify, was = %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the admin API while the
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests pass.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial requests pass, which
+	// decide whether the circuit is closed or opened again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerSettings configures the circuit breaker. Zero values are replaced
+// by the defaults noted below.
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive failed requests which
+	// open the circuit. Defaults to 5.
+	FailureThreshold int
+	// OpenTimeout is the time the circuit stays open before trial requests
+	// are let through. Defaults to 30 seconds.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests which have to succeed
+	// to close the circuit again. Defaults to 1.
+	HalfOpenRequests int
+	// OnStateChange, if set, is called on every transition. It may call
+	// CircuitState.
+	OnStateChange func(from, to CircuitState)
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen after
+// the admin API failed repeatedly, instead of waiting for every request to
+// time out. Failures are transport errors and 5xx responses.
+func WithCircuitBreaker(settings BreakerSettings) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.breaker = newCircuitBreaker(settings)
+	}
+}
+
+// CircuitState returns the state of the circuit breaker, which is always
+// closed if none is configured. It is meant to be reported by health checks.
+func (c *ZeroKitAdminApiClient) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.currentState()
+}
+
+type circuitBreaker struct {
+	settings BreakerSettings
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	trials    int
+	successes int
+	changes   []stateChange
+	now       func() time.Time
+}
+
+type stateChange struct {
+	from, to CircuitState
+}
+
+func newCircuitBreaker(settings BreakerSettings) *circuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = 30 * time.Second
+	}
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = 1
+	}
+	return &circuitBreaker{settings: settings, now: time.Now}
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.unlock()
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.transition(CircuitHalfOpen)
+	}
+	return b.state
+}
+
+// allow reports whether a request may be sent. Every allowed request must be
+// followed by a call to done, or to cancel if it has not been sent. A nil
+// breaker allows all requests.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.unlock()
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.transition(CircuitHalfOpen)
+	}
+	switch b.state {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.trials >= b.settings.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		b.trials++
+	}
+	return nil
+}
+
+// cancel gives back the trial request allowed in the half-open state, which
+// has not been sent after all.
+func (b *circuitBreaker) cancel() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.unlock()
+	if b.state == CircuitHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// done records the outcome of an allowed request.
+func (b *circuitBreaker) done(resp *http.Response, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.unlock()
+
+	if isBreakerFailure(resp, err) {
+		b.failures++
+		if b.state == CircuitHalfOpen ||
+			(b.state == CircuitClosed && b.failures >= b.settings.FailureThreshold) {
+			b.transition(CircuitOpen)
+		}
+		return
+	}
+
+	b.failures = 0
+	if b.state == CircuitHalfOpen {
+		b.successes++
+		if b.successes >= b.settings.HalfOpenRequests {
+			b.transition(CircuitClosed)
+		}
+	}
+}
+
+func (b *circuitBreaker) transition(to CircuitState) {
+	from := b.state
+	b.state = to
+	b.trials, b.successes = 0, 0
+	switch to {
+	case CircuitOpen:
+		b.openedAt = b.now()
+	case CircuitClosed:
+		b.failures = 0
+	}
+	if from != to {
+		b.changes = append(b.changes, stateChange{from: from, to: to})
+	}
+}
+
+// unlock releases b.mu before reporting the state changes made while it was
+// held, so that OnStateChange may query the state of the circuit.
+func (b *circuitBreaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	if b.settings.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.settings.OnStateChange(change.from, change.to)
+	}
+}
+
+// isBreakerFailure reports whether the outcome indicates that the admin API
+// is unavailable. Requests canceled by the caller do not count.
+func isBreakerFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
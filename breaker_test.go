@@ -0,0 +1,173 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	requests := 0
+	var transitions []string
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		requests++
+		return response(status, `{"Members":[]}`), nil
+	}, WithCircuitBreaker(BreakerSettings{
+		FailureThreshold: 3,
+		OpenTimeout:      time.Minute,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}))
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		c.ListTresorMembers("xyz")
+	}
+	if c.CircuitState() != CircuitOpen {
+		t.Fatalf("state = %v, want = %v", c.CircuitState(), CircuitOpen)
+	}
+
+	_, err := c.ListTresorMembers("xyz")
+	if err != ErrCircuitOpen {
+		t.Errorf("err = %v, want = %v", err, ErrCircuitOpen)
+	}
+	if requests != 3 {
+		t.Errorf("requests sent = %d, want = %d", requests, 3)
+	}
+
+	// a failed trial request opens the circuit again
+	now = now.Add(time.Minute)
+	if c.CircuitState() != CircuitHalfOpen {
+		t.Fatalf("state = %v, want = %v", c.CircuitState(), CircuitHalfOpen)
+	}
+	c.ListTresorMembers("xyz")
+	if c.CircuitState() != CircuitOpen {
+		t.Fatalf("state = %v, want = %v", c.CircuitState(), CircuitOpen)
+	}
+
+	// a successful trial request closes it
+	now = now.Add(time.Minute)
+	status = http.StatusOK
+	if _, err := c.ListTresorMembers("xyz"); err != nil {
+		t.Errorf("trial request must not fail, was = %v", err)
+	}
+	if c.CircuitState() != CircuitClosed {
+		t.Errorf("state = %v, want = %v", c.CircuitState(), CircuitClosed)
+	}
+
+	expected := []string{
+		"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}
+	if len(transitions) != len(expected) {
+		t.Fatalf("transitions = %v, want = %v", transitions, expected)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("transitions = %v, want = %v", transitions, expected)
+			break
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		return response(http.StatusBadRequest, ""), nil
+	}, WithCircuitBreaker(BreakerSettings{FailureThreshold: 1}))
+
+	c.ApproveTresorCreation("xyz")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.ApproveTresorCreationContext(ctx, "xyz")
+
+	if c.CircuitState() != CircuitClosed {
+		t.Errorf("state = %v, want = %v", c.CircuitState(), CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerCountsTransportErrors(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}, WithCircuitBreaker(BreakerSettings{FailureThreshold: 2}))
+
+	c.InitUserRegistration()
+	if c.CircuitState() != CircuitClosed {
+		t.Errorf("state = %v, want = %v", c.CircuitState(), CircuitClosed)
+	}
+	c.InitUserRegistration()
+	if c.CircuitState() != CircuitOpen {
+		t.Errorf("state = %v, want = %v", c.CircuitState(), CircuitOpen)
+	}
+}
+
+func TestCircuitStateWithoutBreaker(t *testing.T) {
+	c := newTestClient(t, nil)
+	if c.CircuitState() != CircuitClosed {
+		t.Errorf("state = %v, want = %v", c.CircuitState(), CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerCallbackMayQueryState(t *testing.T) {
+	var c *ZeroKitAdminApiClient
+	var states []CircuitState
+	c = newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusServiceUnavailable, ""), nil
+	}, WithCircuitBreaker(BreakerSettings{
+		FailureThreshold: 1,
+		OnStateChange: func(from, to CircuitState) {
+			states = append(states, c.CircuitState())
+		},
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.ApproveTresorCreation("xyz")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnStateChange calling CircuitState deadlocks")
+	}
+	if len(states) != 1 || states[0] != CircuitOpen {
+		t.Errorf("states = %v, want = %v", states, []CircuitState{CircuitOpen})
+	}
+}
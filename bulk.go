@@ -0,0 +1,273 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BulkOptions configures a bulk user registration.
+type BulkOptions struct {
+	// Concurrency is the number of registrations initiated at the same
+	// time. Defaults to 4.
+	Concurrency int
+	// CheckpointPath, if set, is the file the successful registrations are
+	// appended to. When the job is started again with the same file, the
+	// registrations found in it are not initiated again. The file contains
+	// the registration session verifiers and is created with mode 0600.
+	CheckpointPath string
+}
+
+// BulkRegistrationResult is the outcome of the registration of one user.
+type BulkRegistrationResult struct {
+	// Key identifies the user in the caller's system, e.g. a patient id.
+	Key          string
+	Registration *UserRegistrationData
+	Err          error
+	// Resumed is set if the registration has been read from the
+	// checkpoint instead of being initiated by this run.
+	Resumed bool
+}
+
+// BulkFailure is a registration which could not be initiated.
+type BulkFailure struct {
+	Key string
+	Err error
+}
+
+// BulkSummary summarizes a bulk registration once it has finished.
+type BulkSummary struct {
+	Total     int
+	Succeeded int
+	Resumed   int
+	Failed    int
+	// Canceled is the number of registrations not attempted, because the
+	// context was done first.
+	Canceled int
+	Failures []BulkFailure
+}
+
+func (s BulkSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d registrations: %d succeeded, %d resumed, %d failed, %d canceled",
+		s.Total, s.Succeeded, s.Resumed, s.Failed, s.Canceled)
+	for _, f := range s.Failures {
+		fmt.Fprintf(&b, "\n%s: %v", f.Key, f.Err)
+	}
+	return b.String()
+}
+
+// BulkRegistration is a running bulk user registration.
+type BulkRegistration struct {
+	results chan BulkRegistrationResult
+	done    chan struct{}
+	summary BulkSummary
+}
+
+// Results returns the channel the result of every registration is sent to.
+// It is closed once all registrations have finished. The channel must be
+// drained, otherwise the registration stalls.
+func (b *BulkRegistration) Results() <-chan BulkRegistrationResult {
+	return b.results
+}
+
+// Summary waits for the registration to finish and summarizes it.
+func (b *BulkRegistration) Summary() BulkSummary {
+	<-b.done
+	return b.summary
+}
+
+type checkpointRecord struct {
+	Key          string                `json:"key"`
+	Registration *UserRegistrationData `json:"registration"`
+}
+
+// BulkInitUserRegistration initiates the registration of a user for every
+// one of the unique keys, with bounded concurrency. The results are streamed
+// by the returned BulkRegistration.
+func (c *ZeroKitAdminApiClient) BulkInitUserRegistration(ctx context.Context,
+	keys []string, opts BulkOptions) (*BulkRegistration, error) {
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			return nil, errors.New(fmt.Sprintf("duplicate key: %s", key))
+		}
+		seen[key] = true
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+
+	var (
+		completed  map[string]*UserRegistrationData
+		checkpoint *os.File
+	)
+	if opts.CheckpointPath != "" {
+		var err error
+		completed, checkpoint, err = openCheckpoint(opts.CheckpointPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := &BulkRegistration{
+		results: make(chan BulkRegistrationResult, opts.Concurrency),
+		done:    make(chan struct{}),
+		summary: BulkSummary{Total: len(keys)},
+	}
+	go b.run(ctx, c, keys, completed, checkpoint, opts.Concurrency)
+	return b, nil
+}
+
+func (b *BulkRegistration) run(ctx context.Context, c *ZeroKitAdminApiClient,
+	keys []string, completed map[string]*UserRegistrationData,
+	checkpoint *os.File, concurrency int) {
+	defer close(b.done)
+	defer close(b.results)
+	if checkpoint != nil {
+		defer checkpoint.Close()
+	}
+
+	var mu sync.Mutex
+	report := func(result BulkRegistrationResult) {
+		mu.Lock()
+		switch {
+		case result.Resumed:
+			b.summary.Resumed++
+		case result.Err != nil:
+			b.summary.Failed++
+			b.summary.Failures = append(b.summary.Failures,
+				BulkFailure{Key: result.Key, Err: result.Err})
+		default:
+			b.summary.Succeeded++
+		}
+		mu.Unlock()
+		b.results <- result
+	}
+
+	pending := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range pending {
+				if ctx.Err() != nil {
+					mu.Lock()
+					b.summary.Canceled++
+					mu.Unlock()
+					continue
+				}
+				reg, err := c.InitUserRegistrationContext(ctx)
+				if err == nil && checkpoint != nil {
+					err = appendCheckpoint(&mu, checkpoint, key, reg)
+				}
+				report(BulkRegistrationResult{Key: key, Registration: reg, Err: err})
+			}
+		}()
+	}
+
+	dispatched := 0
+	for _, key := range keys {
+		if reg, ok := completed[key]; ok {
+			report(BulkRegistrationResult{Key: key, Registration: reg, Resumed: true})
+			dispatched++
+			continue
+		}
+		select {
+		case pending <- key:
+			dispatched++
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(pending)
+	wg.Wait()
+
+	b.summary.Canceled += len(keys) - dispatched
+}
+
+// appendCheckpoint records a successful registration. An error means that
+// the registration would be initiated again by a resumed job.
+func appendCheckpoint(mu *sync.Mutex, f *os.File, key string,
+	reg *UserRegistrationData) error {
+	line, err := json.Marshal(checkpointRecord{Key: key, Registration: reg})
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// openCheckpoint opens the checkpoint file for appending, creating it if
+// necessary, and returns the registrations recorded in it. A partial last
+// line, left by a crash, is removed, so that the next record starts on a line
+// of its own.
+func openCheckpoint(path string) (map[string]*UserRegistrationData, *os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := ioutil.ReadAll(f)
+	if err == nil {
+		if complete := bytes.LastIndexByte(content, '\n') + 1; complete < len(content) {
+			err = f.Truncate(int64(complete))
+			content = content[:complete]
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	completed := map[string]*UserRegistrationData{}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		var record checkpointRecord
+		if json.Unmarshal(line, &record) != nil || record.Registration == nil {
+			continue
+		}
+		completed[record.Key] = record.Registration
+	}
+	return completed, f, nil
+}
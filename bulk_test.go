@@ -0,0 +1,214 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// registrations answers every registration with a new user id, failing the
+// ones for which fail returns true.
+func registrations(fail func(n int64) bool) func(*http.Request) (*http.Response, error) {
+	var n int64
+	return func(req *http.Request) (*http.Response, error) {
+		i := atomic.AddInt64(&n, 1)
+		if fail != nil && fail(i) {
+			return response(http.StatusInternalServerError,
+				`{"ErrorCode":"InternalError","ErrorMessage":"try again"}`), nil
+		}
+		return response(http.StatusOK, fmt.Sprintf(
+			`{"UserId":"user%d","RegSessionId":"session%d","RegSessionVerifier":"verifier%d"}`,
+			i, i, i)), nil
+	}
+}
+
+func collect(b *BulkRegistration) map[string]BulkRegistrationResult {
+	results := map[string]BulkRegistrationResult{}
+	for result := range b.Results() {
+		results[result.Key] = result
+	}
+	return results
+}
+
+func TestBulkInitUserRegistration(t *testing.T) {
+	var inFlight, maxInFlight int64
+	next := registrations(func(n int64) bool { return n == 2 })
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			max := atomic.LoadInt64(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, n) {
+				break
+			}
+		}
+		return next(req)
+	})
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	b, err := c.BulkInitUserRegistration(context.Background(), keys,
+		BulkOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := collect(b)
+	summary := b.Summary()
+
+	if len(results) != len(keys) {
+		t.Fatalf("expected %d results, got %d", len(keys), len(results))
+	}
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 registrations in flight, got %d", maxInFlight)
+	}
+	if summary.Total != 6 || summary.Succeeded != 5 || summary.Failed != 1 {
+		t.Errorf("unexpected summary: %v", summary)
+	}
+	failed := summary.Failures[0]
+	if results[failed.Key].Err == nil || results[failed.Key].Registration != nil {
+		t.Errorf("expected failed result for %s, got %+v", failed.Key, results[failed.Key])
+	}
+}
+
+func TestBulkInitUserRegistrationResume(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	keys := []string{"a", "b", "c", "d"}
+
+	c := newTestClient(t, registrations(func(n int64) bool { return n%2 == 0 }))
+	b, err := c.BulkInitUserRegistration(context.Background(), keys,
+		BulkOptions{Concurrency: 1, CheckpointPath: checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := collect(b)
+	if summary := b.Summary(); summary.Succeeded != 2 || summary.Failed != 2 {
+		t.Fatalf("unexpected summary of first run: %v", summary)
+	}
+
+	var requests int64
+	next := registrations(nil)
+	c = newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&requests, 1)
+		return next(req)
+	})
+	b, err = c.BulkInitUserRegistration(context.Background(), keys,
+		BulkOptions{Concurrency: 1, CheckpointPath: checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := collect(b)
+	summary := b.Summary()
+	if summary.Resumed != 2 || summary.Succeeded != 2 || summary.Failed != 0 {
+		t.Fatalf("unexpected summary of resumed run: %v", summary)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 registrations to be retried, got %d", requests)
+	}
+	for key, result := range first {
+		if result.Err != nil {
+			continue
+		}
+		resumed := second[key]
+		if !resumed.Resumed || *resumed.Registration != *result.Registration {
+			t.Errorf("expected registration of %s to be resumed, got %+v", key, resumed)
+		}
+	}
+}
+
+func TestBulkInitUserRegistrationResumeFromTornCheckpoint(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	var requests int64
+	next := registrations(nil)
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&requests, 1)
+		return next(req)
+	})
+	run := func(keys ...string) BulkSummary {
+		b, err := c.BulkInitUserRegistration(context.Background(), keys,
+			BulkOptions{Concurrency: 1, CheckpointPath: checkpoint})
+		if err != nil {
+			t.Fatal(err)
+		}
+		collect(b)
+		return b.Summary()
+	}
+
+	run("a", "b")
+	// a crash while the registration of c was recorded
+	f, _ := os.OpenFile(checkpoint, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"key":"c","regis`)
+	f.Close()
+
+	if summary := run("a", "b", "c", "d"); summary.Resumed != 2 || summary.Succeeded != 2 {
+		t.Fatalf("unexpected summary of first resume: %v", summary)
+	}
+	atomic.StoreInt64(&requests, 0)
+	if summary := run("a", "b", "c", "d"); summary.Resumed != 4 || summary.Succeeded != 0 {
+		t.Fatalf("unexpected summary of second resume: %v", summary)
+	}
+	if requests != 0 {
+		t.Errorf("expected no registration to be initiated again, got %d", requests)
+	}
+}
+
+func TestBulkInitUserRegistrationCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	next := registrations(nil)
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return next(req)
+	})
+
+	b, err := c.BulkInitUserRegistration(ctx, []string{"a", "b", "c"},
+		BulkOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(b)
+	summary := b.Summary()
+	if summary.Succeeded != 1 || summary.Canceled != 2 {
+		t.Errorf("unexpected summary: %v", summary)
+	}
+}
+
+func TestBulkInitUserRegistrationDuplicateKey(t *testing.T) {
+	c := newTestClient(t, registrations(nil))
+	_, err := c.BulkInitUserRegistration(context.Background(),
+		[]string{"a", "b", "a"}, BulkOptions{})
+	if err == nil {
+		t.Error("expected duplicate key to be rejected")
+	}
+}
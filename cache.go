@@ -0,0 +1,230 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// WithMembersCache caches the results of ListTresorMembers for ttl, keeping
+// the members of at most size tresors. Concurrent lookups of the same tresor
+// are served by a single request. The cached members of a tresor are dropped
+// when the client approves its creation, and all cached members are dropped
+// when the client approves a share or a kick, as the pending operation does
+// not tell the tresor it applies to.
+func WithMembersCache(ttl time.Duration, size int) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.membersCache = newMembersCache(ttl, size)
+	}
+}
+
+// InvalidateTresorMembers drops the cached members of the given tresor, e.g.
+// after its membership has been changed by another client.
+func (c *ZeroKitAdminApiClient) InvalidateTresorMembers(tresorId string) {
+	if c.membersCache != nil {
+		c.membersCache.invalidate(tresorId)
+	}
+}
+
+func (c *ZeroKitAdminApiClient) invalidateAllTresorMembers() {
+	if c.membersCache != nil {
+		c.membersCache.invalidateAll()
+	}
+}
+
+// membersCache is an LRU cache of tresor members with expiring entries.
+type membersCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	flights map[string]*flight
+	// generation is increased by every invalidation, so that a lookup which
+	// was in flight while the members changed does not fill the cache.
+	generation uint64
+}
+
+type cacheEntry struct {
+	tresorId string
+	members  []string
+	expires  time.Time
+}
+
+// flight is a lookup in progress, which concurrent lookups wait for. It is
+// canceled once all of them have stopped waiting.
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	members []string
+	err     error
+}
+
+func newMembersCache(ttl time.Duration, size int) *membersCache {
+	if size < 1 {
+		size = 1
+	}
+	return &membersCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		flights: map[string]*flight{},
+	}
+}
+
+// load returns the cached members of the tresor, or looks them up with fetch
+// if they are not cached, unless a lookup is in flight already. The lookup is
+// shared by all callers, so it is not canceled along with the context of the
+// caller which started it, but only once the contexts of all callers waiting
+// for it are done.
+func (m *membersCache) load(ctx context.Context, tresorId string,
+	fetch func(context.Context, string) ([]string, error)) ([]string, error) {
+	m.mu.Lock()
+	if members, ok := m.get(tresorId); ok {
+		m.mu.Unlock()
+		return members, nil
+	}
+	f, ok := m.flights[tresorId]
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		m.flights[tresorId] = f
+		go m.fetch(fetchCtx, tresorId, f, m.generation, fetch)
+	}
+	f.waiters++
+	m.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return copyMembers(f.members), f.err
+	case <-ctx.Done():
+		m.leave(tresorId, f)
+		return nil, ctx.Err()
+	}
+}
+
+// leave cancels the flight if no caller is waiting for it anymore, so that
+// a lookup the tenant never answers does not block the later ones.
+func (m *membersCache) leave(tresorId string, f *flight) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f.waiters--
+	if f.waiters == 0 {
+		f.cancel()
+		if m.flights[tresorId] == f {
+			delete(m.flights, tresorId)
+		}
+	}
+}
+
+func (m *membersCache) fetch(ctx context.Context, tresorId string, f *flight,
+	generation uint64, fetch func(context.Context, string) ([]string, error)) {
+	f.members, f.err = fetch(ctx, tresorId)
+	f.cancel()
+
+	m.mu.Lock()
+	if m.flights[tresorId] == f {
+		delete(m.flights, tresorId)
+	}
+	if f.err == nil && generation == m.generation {
+		m.set(tresorId, f.members)
+	}
+	m.mu.Unlock()
+	close(f.done)
+}
+
+func (m *membersCache) get(tresorId string) ([]string, bool) {
+	e, ok := m.entries[tresorId]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !m.now().Before(entry.expires) {
+		m.lru.Remove(e)
+		delete(m.entries, tresorId)
+		return nil, false
+	}
+	m.lru.MoveToFront(e)
+	return copyMembers(entry.members), true
+}
+
+func (m *membersCache) set(tresorId string, members []string) {
+	entry := &cacheEntry{
+		tresorId: tresorId,
+		members:  copyMembers(members),
+		expires:  m.now().Add(m.ttl),
+	}
+	if e, ok := m.entries[tresorId]; ok {
+		e.Value = entry
+		m.lru.MoveToFront(e)
+		return
+	}
+	m.entries[tresorId] = m.lru.PushFront(entry)
+	for m.lru.Len() > m.size {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*cacheEntry).tresorId)
+	}
+}
+
+func (m *membersCache) invalidate(tresorId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generation++
+	if e, ok := m.entries[tresorId]; ok {
+		m.lru.Remove(e)
+		delete(m.entries, tresorId)
+	}
+}
+
+func (m *membersCache) invalidateAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generation++
+	m.entries = map[string]*list.Element{}
+	m.lru.Init()
+}
+
+// copyMembers keeps callers from modifying the cached members.
+func copyMembers(members []string) []string {
+	if members == nil {
+		return nil
+	}
+	return append([]string(nil), members...)
+}
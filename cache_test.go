@@ -0,0 +1,261 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func membersTenant(lookups *int32, release chan struct{}) func(
+	req *http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != ListTresorMembersPath {
+			return response(http.StatusOK, ""), nil
+		}
+		atomic.AddInt32(lookups, 1)
+		if release != nil {
+			<-release
+		}
+		return response(http.StatusOK,
+			`{"Members":["`+req.URL.Query().Get("tresorid")+`-zk"]}`), nil
+	}
+}
+
+func TestMembersCacheTTL(t *testing.T) {
+	var lookups int32
+	c := newTestClient(t, membersTenant(&lookups, nil),
+		WithMembersCache(time.Minute, 10))
+	now := time.Now()
+	c.membersCache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		members, err := c.ListTresorMembers("xyz")
+		if err != nil || len(members) != 1 || members[0] != "xyz-zk" {
+			t.Fatalf("members = %v, %v", members, err)
+		}
+		members[0] = "modified"
+	}
+	if lookups != 1 {
+		t.Errorf("lookups = %d, want = %d", lookups, 1)
+	}
+
+	now = now.Add(time.Minute)
+	c.ListTresorMembers("xyz")
+	if lookups != 2 {
+		t.Errorf("lookups after expiry = %d, want = %d", lookups, 2)
+	}
+}
+
+func TestMembersCacheLRU(t *testing.T) {
+	var lookups int32
+	c := newTestClient(t, membersTenant(&lookups, nil),
+		WithMembersCache(time.Minute, 2))
+
+	c.ListTresorMembers("a")
+	c.ListTresorMembers("b")
+	c.ListTresorMembers("a")
+	c.ListTresorMembers("c") // evicts b, the least recently used
+	c.ListTresorMembers("a")
+	if lookups != 3 {
+		t.Errorf("lookups = %d, want = %d", lookups, 3)
+	}
+	c.ListTresorMembers("b")
+	if lookups != 4 {
+		t.Errorf("lookups = %d, want = %d", lookups, 4)
+	}
+}
+
+func TestMembersCacheSingleflight(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	c := newTestClient(t, membersTenant(&lookups, release),
+		WithMembersCache(time.Minute, 10))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			members, err := c.ListTresorMembers("xyz")
+			if err != nil || len(members) != 1 {
+				t.Errorf("members = %v, %v", members, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if lookups != 1 {
+		t.Errorf("lookups = %d, want = %d", lookups, 1)
+	}
+}
+
+func TestMembersCacheWaitHonorsContext(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestClient(t, membersTenant(&lookups, release),
+		WithMembersCache(time.Minute, 10))
+
+	go c.ListTresorMembers("xyz")
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListTresorMembersContext(ctx, "xyz"); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want = %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestMembersCacheInvalidation(t *testing.T) {
+	var lookups int32
+	c := newTestClient(t, membersTenant(&lookups, nil),
+		WithMembersCache(time.Minute, 10))
+
+	c.ListTresorMembers("a")
+	c.ListTresorMembers("b")
+	c.ApproveTresorCreation("a")
+	c.ListTresorMembers("a")
+	c.ListTresorMembers("b")
+	if lookups != 3 {
+		t.Errorf("lookups after creation approval = %d, want = %d", lookups, 3)
+	}
+
+	c.ApproveShare("operation")
+	c.ListTresorMembers("a")
+	c.ListTresorMembers("b")
+	if lookups != 5 {
+		t.Errorf("lookups after share approval = %d, want = %d", lookups, 5)
+	}
+
+	c.ApproveKick("operation")
+	c.ListTresorMembers("b")
+	if lookups != 6 {
+		t.Errorf("lookups after kick approval = %d, want = %d", lookups, 6)
+	}
+}
+
+func TestMembersCacheDropsLookupInFlightDuringInvalidation(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	c := newTestClient(t, membersTenant(&lookups, release),
+		WithMembersCache(time.Minute, 10))
+
+	done := make(chan struct{})
+	go func() {
+		c.ListTresorMembers("xyz")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.InvalidateTresorMembers("xyz")
+	close(release)
+	<-done
+
+	c.ListTresorMembers("xyz")
+	if lookups != 2 {
+		t.Errorf("lookups = %d, want = %d", lookups, 2)
+	}
+}
+
+func TestMembersCacheLookupOutlivesFirstCaller(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		return response(http.StatusOK, `{"Members":["zk1"]}`), nil
+	}, WithMembersCache(time.Minute, 10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.ListTresorMembersContext(ctx, "xyz")
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan error)
+	go func() {
+		members, err := c.ListTresorMembers("xyz")
+		if err == nil && len(members) != 1 {
+			t.Errorf("members = %v, want = [zk1]", members)
+		}
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("first caller: err = %v, want = %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("second caller: err = %v, want = nil", err)
+	}
+}
+
+func TestMembersCacheCancelsLookupTheTenantNeverAnswers(t *testing.T) {
+	var lookups int32
+	abandoned := make(chan struct{})
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			<-req.Context().Done()
+			close(abandoned)
+			return nil, req.Context().Err()
+		}
+		return response(http.StatusOK, `{"Members":["zk1"]}`), nil
+	}, WithMembersCache(time.Minute, 10))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListTresorMembersContext(ctx, "xyz"); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want = %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-abandoned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup must be canceled once no caller waits for it")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	members, err := c.ListTresorMembersContext(ctx, "xyz")
+	if err != nil || len(members) != 1 {
+		t.Errorf("later lookup: members = %v, err = %v", members, err)
+	}
+}
@@ -0,0 +1,287 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxResponseSize is the maximum number of bytes read from the body
+// of an admin API response.
+const DefaultMaxResponseSize = 1 << 20
+
+// ErrResponseTooLarge is returned for responses exceeding the maximum size.
+var ErrResponseTooLarge = errors.New("admin API response exceeds the maximum size")
+
+// APIError is returned when the admin API responds with a status code other
+// than 2xx.
+type APIError struct {
+	Operation    string
+	StatusCode   int
+	ErrorCode    string `json:"ErrorCode"`
+	ErrorMessage string `json:"ErrorMessage"`
+	// Body is the raw response body, in case it could not be decoded.
+	Body []byte `json:"-"`
+
+	alreadyDone bool
+}
+
+// ErrAlreadyDone matches the APIError of an approval or validation which
+// has been made before, e.g. by a retried request, as recognized by the
+// codes of WithAlreadyDoneCodes. Use errors.Is to check for it.
+var ErrAlreadyDone = errors.New("operation has already been done")
+
+// repeatableOperations are the operations whose repetition can be
+// recognized by the error codes of WithAlreadyDoneCodes.
+var repeatableOperations = map[string]bool{
+	"ApproveTresorCreation":    true,
+	"ApproveShare":             true,
+	"ApproveKick":              true,
+	"ValidateUserRegistration": true,
+}
+
+// WithAlreadyDoneCodes sets the error codes with which the tenant refuses to
+// repeat an approval or validation. The admin API reference does not
+// document such codes, so none are recognized by default; take them from the
+// responses of your tenant, as a generic code would hide real failures.
+func WithAlreadyDoneCodes(codes ...string) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.alreadyDoneCodes = append(c.alreadyDoneCodes, codes...)
+	}
+}
+
+// WithAlreadyDoneAsSuccess treats approvals and validations which have been
+// made before as successful, instead of failing with ErrAlreadyDone.
+func WithAlreadyDoneAsSuccess() Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.alreadyDoneAsSuccess = true
+	}
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s failed with status %d", e.Operation, e.StatusCode)
+	if e.ErrorCode != "" {
+		msg += ": " + e.ErrorCode
+	}
+	if e.ErrorMessage != "" {
+		msg += ": " + e.ErrorMessage
+	}
+	return msg
+}
+
+// Is reports whether the error matches ErrAlreadyDone.
+func (e *APIError) Is(target error) bool {
+	return target == ErrAlreadyDone && e.alreadyDone
+}
+
+// isAlreadyDone reports whether the error refuses to repeat the operation.
+func (c *ZeroKitAdminApiClient) isAlreadyDone(e *APIError) bool {
+	if !repeatableOperations[e.Operation] || e.ErrorCode == "" {
+		return false
+	}
+	for _, code := range c.alreadyDoneCodes {
+		if e.ErrorCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeError is returned when a successful admin API response cannot be
+// decoded, or lacks required fields.
+type DecodeError struct {
+	Operation string
+	// Missing lists the required fields absent from the response.
+	Missing []string
+	// Err is the error decoding the response, if any.
+	Err error
+	// Body is the raw response body.
+	Body []byte `json:"-"`
+}
+
+func (e *DecodeError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: invalid response: %v", e.Operation, e.Err)
+	}
+	return fmt.Sprintf("%s: response lacks required fields %s", e.Operation,
+		strings.Join(e.Missing, ", "))
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// requiredFields is implemented by responses with required fields.
+type requiredFields interface {
+	// missingFields returns the names of the required fields which are
+	// absent.
+	missingFields() []string
+}
+
+// WithMaxResponseSize sets the maximum number of bytes read from the body
+// of a response. Larger responses fail with ErrResponseTooLarge. The default
+// is DefaultMaxResponseSize.
+func WithMaxResponseSize(n int64) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.maxResponseSize = n
+	}
+}
+
+// WithStrictDecoding rejects responses with fields unknown to the client,
+// which are ignored by default, with a DecodeError.
+func WithStrictDecoding() Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.strictDecoding = true
+	}
+}
+
+// endpoint describes an admin API operation.
+type endpoint struct {
+	operation string
+	method    string
+	path      string
+}
+
+var (
+	listTresorMembers = endpoint{
+		"ListTresorMembers", "GET", ListTresorMembersPath,
+	}
+	initUserRegistration = endpoint{
+		"InitUserRegistration", "POST", InitiateUserRegistrationPath,
+	}
+	approveTresorCreation = endpoint{
+		"ApproveTresorCreation", "POST", ApproveTresorCreationPath,
+	}
+	validateUserRegistration = endpoint{
+		"ValidateUserRegistration", "POST", ValidateUserRegistrationPath,
+	}
+	approveShare = endpoint{
+		"ApproveShare", "POST", ApproveShareOperationPath,
+	}
+	approveKick = endpoint{
+		"ApproveKick", "POST", ApproveKickOperationPath,
+	}
+)
+
+// call performs the admin API operation described by e. The request body is
+// encoded from req, unless it is nil, and a successful response is decoded
+// into a new Resp. An empty response body yields the zero Resp, which is
+// rejected if Resp has required fields.
+func call[Req, Resp any](ctx context.Context, c *ZeroKitAdminApiClient,
+	e endpoint, query url.Values, req *Req) (*Resp, error) {
+	var body []byte
+	if req != nil {
+		var err error
+		body, err = json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.doSigned(ctx, e.operation, e.method, e.path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := readLimited(resp.Body, c.maxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			Operation:  e.operation,
+			StatusCode: resp.StatusCode,
+			Body:       content,
+		}
+		json.Unmarshal(content, apiErr)
+		apiErr.alreadyDone = c.isAlreadyDone(apiErr)
+		if apiErr.alreadyDone && c.alreadyDoneAsSuccess {
+			return new(Resp), nil
+		}
+		return nil, apiErr
+	}
+
+	var out Resp
+	if len(content) > 0 {
+		if err := c.decode(content, &out); err != nil {
+			return nil, &DecodeError{Operation: e.operation, Err: err, Body: content}
+		}
+	}
+	if r, ok := any(&out).(requiredFields); ok {
+		if missing := r.missingFields(); len(missing) > 0 {
+			return nil, &DecodeError{
+				Operation: e.operation,
+				Missing:   missing,
+				Body:      content,
+			}
+		}
+	}
+	return &out, nil
+}
+
+// decode decodes the JSON content into v. Strict decoding rejects unknown
+// fields and anything following the JSON value.
+func (c *ZeroKitAdminApiClient) decode(content []byte, v interface{}) error {
+	if !c.strictDecoding {
+		return json.Unmarshal(content, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// readLimited reads the whole body, unless it is larger than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, ErrResponseTooLarge
+	}
+	return content, nil
+}
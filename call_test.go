@@ -0,0 +1,303 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestClient(t *testing.T, doMock func(req *http.Request) (*http.Response,
+	error), opts ...Option) *ZeroKitAdminApiClient {
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey, opts...)
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = &mockHttpClient{DoMock: doMock}
+	return c
+}
+
+func response(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+	}
+}
+
+type testRequest struct {
+	UserId string
+}
+
+type testResponse struct {
+	Result string
+}
+
+func TestCall(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		if req.Method != "PUT" {
+			t.Errorf("method = %s, want = %s", req.Method, "PUT")
+		}
+		if req.URL.Query().Get("tresorid") != "xyz" {
+			t.Errorf("tresorid query parameter = %s, want = %s",
+				req.URL.Query().Get("tresorid"), "xyz")
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != `{"UserId":"zk"}` {
+			t.Errorf("body = %s, want = %s", body, `{"UserId":"zk"}`)
+		}
+		return response(http.StatusOK, `{"Result":"ok"}`), nil
+	})
+
+	e := endpoint{"Test", "PUT", "/api/v4/admin/test"}
+	out, err := call[testRequest, testResponse](context.Background(), c, e,
+		url.Values{"tresorid": {"xyz"}}, &testRequest{UserId: "zk"})
+	if err != nil {
+		t.Fatalf("call must not fail, was = %v", err)
+	}
+	if out.Result != "ok" {
+		t.Errorf("Result = %s, want = %s", out.Result, "ok")
+	}
+}
+
+func TestCallEmptyResponse(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil && req.Body != http.NoBody {
+			t.Error("request without input must not have a body")
+		}
+		return response(http.StatusNoContent, ""), nil
+	})
+
+	e := endpoint{"Test", "DELETE", "/api/v4/admin/test"}
+	out, err := call[struct{}, testResponse](context.Background(), c, e, nil, nil)
+	if err != nil {
+		t.Fatalf("call must not fail, was = %v", err)
+	}
+	if out.Result != "" {
+		t.Errorf("Result = %s, want = %s", out.Result, "")
+	}
+}
+
+func TestCallAPIError(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusBadRequest,
+			`{"ErrorCode":"BadInput","ErrorMessage":"TresorId is invalid"}`), nil
+	})
+
+	err := c.ApproveTresorCreation("xyz")
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("StatusCode = %d, want = %d",
+			apiErr.StatusCode, http.StatusBadRequest)
+	}
+	if apiErr.ErrorCode != "BadInput" || apiErr.ErrorMessage != "TresorId is invalid" {
+		t.Errorf("unexpected error code and message: %+v", apiErr)
+	}
+	if apiErr.Operation != "ApproveTresorCreation" {
+		t.Errorf("Operation = %s, want = %s",
+			apiErr.Operation, "ApproveTresorCreation")
+	}
+}
+
+func TestCallAPIErrorWithoutJSON(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusBadGateway, "<html>Bad Gateway</html>"), nil
+	})
+
+	_, err := c.InitUserRegistration()
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if string(apiErr.Body) != "<html>Bad Gateway</html>" {
+		t.Errorf("Body = %s, want = %s", apiErr.Body, "<html>Bad Gateway</html>")
+	}
+}
+
+func TestCallResponseTooLarge(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		members := strings.Repeat(`"zk",`, DefaultMaxResponseSize/5)
+		return response(http.StatusOK, `{"Members":[`+members+`"zk"]}`), nil
+	})
+
+	_, err := c.ListTresorMembers("xyz")
+	if err != ErrResponseTooLarge {
+		t.Errorf("err = %v, want = %v", err, ErrResponseTooLarge)
+	}
+}
+
+func TestCallMaxResponseSize(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":["zk1","zk2"]}`), nil
+	}, WithMaxResponseSize(16))
+
+	_, err := c.ListTresorMembers("xyz")
+	if err != ErrResponseTooLarge {
+		t.Errorf("err = %v, want = %v", err, ErrResponseTooLarge)
+	}
+}
+
+func TestCallMissingRequiredFields(t *testing.T) {
+	body := `{"RegSessionId":"session","UserId":""}`
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == ListTresorMembersPath {
+			return response(http.StatusOK, `{"Users":["zk1"]}`), nil
+		}
+		return response(http.StatusOK, body), nil
+	})
+
+	_, err := c.ListTresorMembers("xyz")
+	decodeErr, ok := err.(*DecodeError)
+	if !ok || !reflect.DeepEqual(decodeErr.Missing, []string{"Members"}) {
+		t.Errorf("err = %v, want *DecodeError missing Members", err)
+	}
+
+	_, err = c.InitUserRegistration()
+	decodeErr, ok = err.(*DecodeError)
+	if !ok {
+		t.Fatalf("err = %v, want *DecodeError", err)
+	}
+	if want := []string{"RegSessionVerifier", "UserId"}; !reflect.DeepEqual(decodeErr.Missing, want) {
+		t.Errorf("Missing = %v, want = %v", decodeErr.Missing, want)
+	}
+	if decodeErr.Operation != "InitUserRegistration" || string(decodeErr.Body) != body {
+		t.Errorf("unexpected decode error: %+v", decodeErr)
+	}
+}
+
+func TestCallInvalidJSON(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":["zk1"`), nil
+	})
+
+	_, err := c.ListTresorMembers("xyz")
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Err == nil {
+		t.Errorf("err = %v, want *DecodeError", err)
+	}
+}
+
+func TestCallStrictDecoding(t *testing.T) {
+	mock := func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":["zk1"],"Owner":"zk1"}`), nil
+	}
+
+	if _, err := newTestClient(t, mock).ListTresorMembers("xyz"); err != nil {
+		t.Errorf("unknown fields must be ignored by default, was = %v", err)
+	}
+	_, err := newTestClient(t, mock, WithStrictDecoding()).ListTresorMembers("xyz")
+	if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("err = %v, want *DecodeError", err)
+	}
+}
+
+func TestCallStrictDecodingTrailingData(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":["zk1"]} {"Members":["zk2"]}`), nil
+	}, WithStrictDecoding())
+
+	_, err := c.ListTresorMembers("xyz")
+	if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("err = %v, want *DecodeError", err)
+	}
+}
+
+func TestCallAlreadyDone(t *testing.T) {
+	code := ""
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusBadRequest,
+			`{"ErrorCode":"`+code+`","ErrorMessage":"repeated"}`), nil
+	}, WithAlreadyDoneCodes("TresorAlreadyApproved", "OperationAlreadyApproved",
+		"AlreadyApproved", "UserAlreadyValidated"))
+
+	for errorCode, op := range map[string]func() error{
+		"TresorAlreadyApproved":    func() error { return c.ApproveTresorCreation("xyz") },
+		"OperationAlreadyApproved": func() error { return c.ApproveShare("op") },
+		"AlreadyApproved":          func() error { return c.ApproveKick("op") },
+		"UserAlreadyValidated": func() error {
+			return c.ValidateUserRegistration("zk", "session", "verifier", "validation")
+		},
+	} {
+		code = errorCode
+		err := op()
+		if !errors.Is(err, ErrAlreadyDone) {
+			t.Errorf("%s: err = %v, want = %v", errorCode, err, ErrAlreadyDone)
+		}
+		if _, ok := err.(*APIError); !ok {
+			t.Errorf("%s: err = %v, want *APIError", errorCode, err)
+		}
+	}
+
+	code = "BadInput"
+	if err := c.ApproveTresorCreation("xyz"); err == nil || errors.Is(err, ErrAlreadyDone) {
+		t.Errorf("err = %v, want an error other than %v", err, ErrAlreadyDone)
+	}
+	code = "AlreadyApproved"
+	if _, err := c.InitUserRegistration(); errors.Is(err, ErrAlreadyDone) {
+		t.Errorf("error of a lookup must not match %v", ErrAlreadyDone)
+	}
+
+	c = newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusBadRequest, `{"ErrorCode":"AlreadyApproved"}`), nil
+	})
+	if err := c.ApproveTresorCreation("xyz"); errors.Is(err, ErrAlreadyDone) {
+		t.Errorf("no error code must match %v by default", ErrAlreadyDone)
+	}
+}
+
+func TestCallAlreadyDoneAsSuccess(t *testing.T) {
+	code := "TresorAlreadyApproved"
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusConflict, `{"ErrorCode":"`+code+`"}`), nil
+	}, WithAlreadyDoneAsSuccess(), WithAlreadyDoneCodes("TresorAlreadyApproved"),
+		WithAlreadyDoneCodes("Conflict"))
+
+	if err := c.ApproveTresorCreation("xyz"); err != nil {
+		t.Errorf("repeated approval must not fail, was = %v", err)
+	}
+	code = "Conflict"
+	if err := c.ApproveShare("op"); err != nil {
+		t.Errorf("repeated approval with added code must not fail, was = %v", err)
+	}
+	code = "BadInput"
+	if err := c.ApproveShare("op"); err == nil {
+		t.Error("other errors must not be treated as success")
+	}
+}
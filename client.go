@@ -32,35 +32,128 @@ package zerokit
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
-	"errors"
 )
 
+// The paths of the admin API endpoints, relative to the service url.
 const (
 	ListTresorMembersPath        = "/api/v4/admin/tresor/list-members"
 	InitiateUserRegistrationPath = "/api/v4/admin/user/init-user-registration"
 	ApproveTresorCreationPath    = "/api/v4/admin/tresor/approve-tresor-creation"
 	ValidateUserRegistrationPath = "/api/v4/admin/user/validate-user-registration"
+	ApproveShareOperationPath    = "/api/v4/admin/tresor/approve-share"
+	ApproveKickOperationPath     = "/api/v4/admin/tresor/approve-kick"
 )
 
+// ZeroKitAdminApiClient calls the admin API of a ZeroKit tenant, signing
+// every request with the admin key. It is safe for concurrent use.
 type ZeroKitAdminApiClient struct {
 	requestSigner
-	httpClient httpClient
+	httpClient HttpClient
+	tracer     Tracer
+	auditSink  AuditSink
 	ServiceUrl url.URL
+
+	maxResponseSize int64
+	strictDecoding  bool
+	limiter         *tokenBucket
+	inFlight        chan struct{}
+	breaker         *circuitBreaker
+	membersCache    *membersCache
+
+	alreadyDoneCodes     []string
+	alreadyDoneAsSuccess bool
+}
+
+// AdminClient are the admin API operations of a ZeroKit tenant. It is
+// implemented by *ZeroKitAdminApiClient and allows consumers to substitute
+// the client in their tests.
+type AdminClient interface {
+	ListTresorMembers(tresorId string) ([]string, error)
+	ListTresorMembersContext(ctx context.Context, tresorId string) ([]string, error)
+	InitUserRegistration() (*UserRegistrationData, error)
+	InitUserRegistrationContext(ctx context.Context) (*UserRegistrationData, error)
+	ApproveTresorCreation(tresorId string) error
+	ApproveTresorCreationContext(ctx context.Context, tresorId string) error
+	ApproveShare(operationId string) error
+	ApproveShareContext(ctx context.Context, operationId string) error
+	ApproveKick(operationId string) error
+	ApproveKickContext(ctx context.Context, operationId string) error
+	ValidateUserRegistration(zeroKitId, sessionId, sessionVerifier,
+		validationVerifier string) error
+	ValidateUserRegistrationContext(ctx context.Context, zeroKitId, sessionId,
+		sessionVerifier, validationVerifier string) error
 }
 
-type httpClient interface {
+// ExtendedAdminClient adds the helpers built on the admin operations to
+// AdminClient. It is separate from AdminClient, so that existing
+// implementations of AdminClient remain valid.
+type ExtendedAdminClient interface {
+	AdminClient
+	ListTresorMembersDetailed(tresorId string) (*TresorMembers, error)
+	ListTresorMembersDetailedContext(ctx context.Context,
+		tresorId string) (*TresorMembers, error)
+	IsMember(tresorId, userId string) (bool, error)
+	IsMemberContext(ctx context.Context, tresorId, userId string) (bool, error)
+	ValidateUser(req ValidateUserRegistrationRequest) error
+	ValidateUserContext(ctx context.Context, req ValidateUserRegistrationRequest) error
+	ListMembers(tresorId TresorId) ([]UserId, error)
+	ListMembersContext(ctx context.Context, tresorId TresorId) ([]UserId, error)
+	ApproveTresor(tresorId TresorId) error
+	ApproveTresorContext(ctx context.Context, tresorId TresorId) error
+	ApproveShareOperation(operationId OperationId) error
+	ApproveShareOperationContext(ctx context.Context, operationId OperationId) error
+	ApproveKickOperation(operationId OperationId) error
+	ApproveKickOperationContext(ctx context.Context, operationId OperationId) error
+}
+
+var _ ExtendedAdminClient = (*ZeroKitAdminApiClient)(nil)
+
+// HttpClient sends the signed requests to the admin API. It is implemented
+// by *http.Client.
+type HttpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// Option configures optional behaviour of the ZeroKitAdminApiClient.
+type Option func(*ZeroKitAdminApiClient)
+
+// WithHttpClient sets the client used to send the requests to the admin API.
+// http.DefaultClient is used by default.
+func WithHttpClient(hc HttpClient) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.httpClient = hc
+	}
+}
+
+// NewZeroKitAdminApiClient creates a client for the tenant at serviceUrl,
+// which signs the requests with the hex encoded admin key of adminUserId.
 func NewZeroKitAdminApiClient(serviceUrl, adminUserId,
-	adminKey string) (*ZeroKitAdminApiClient, error) {
-	if serviceUrl == "" || adminKey == "" || adminUserId == "" {
+	adminKey string, opts ...Option) (*ZeroKitAdminApiClient, error) {
+	if adminKey == "" {
+		return nil, errors.New("one or more arguments are empty")
+	}
+	keys, err := newStaticKey(adminKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewZeroKitAdminApiClientWithKeyProvider(serviceUrl, adminUserId,
+		keys, opts...)
+}
+
+// NewZeroKitAdminApiClientWithKeyProvider creates a client which signs the
+// requests with the admin key supplied by the given KeyProvider. The current
+// key is validated up front, so a malformed key is reported here rather than
+// by the first request.
+func NewZeroKitAdminApiClientWithKeyProvider(serviceUrl, adminUserId string,
+	keys KeyProvider, opts ...Option) (*ZeroKitAdminApiClient, error) {
+	if serviceUrl == "" || adminUserId == "" || keys == nil {
 		return nil, errors.New("one or more arguments are empty")
 	}
 
@@ -69,156 +162,256 @@ func NewZeroKitAdminApiClient(serviceUrl, adminUserId,
 		return nil, errors.New(fmt.Sprintf("invalid service url: %s", serviceUrl))
 	}
 
-	return &ZeroKitAdminApiClient{
+	c := &ZeroKitAdminApiClient{
 		requestSigner: requestSigner{
-			adminKey:    adminKey,
+			keys:        keys,
 			adminUserId: adminUserId,
 		},
 		httpClient: http.DefaultClient,
+		tracer:     noopTracer{},
 		ServiceUrl: *u,
-	}, nil
+
+		maxResponseSize: DefaultMaxResponseSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	for _, keys := range []KeyProvider{c.keys, c.secondary} {
+		if keys == nil {
+			continue
+		}
+		if err := useAdminKey(keys, func([]byte) {}); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close wipes the admin keys kept in memory by the client. The client cannot
+// sign any requests afterwards.
+func (c *ZeroKitAdminApiClient) Close() error {
+	c.wipe()
+	return nil
 }
 
-func (c *ZeroKitAdminApiClient) doSignedPost(urlPath string,
-	body []byte) (*http.Response, error) {
+// doSigned sends a signed request with the given method to the admin API
+// endpoint. The query and the body are optional.
+func (c *ZeroKitAdminApiClient) doSigned(ctx context.Context, operation,
+	method, urlPath string, query url.Values, body []byte) (*http.Response, error) {
 	endpoint := c.ServiceUrl
 	endpoint.Path = path.Join(endpoint.Path, urlPath)
-	r, err := http.NewRequest("POST", endpoint.String(), bytes.NewBuffer(body))
+	var content io.Reader
+	if body != nil {
+		content = bytes.NewReader(body)
+	}
+	r, err := http.NewRequest(method, endpoint.String(), content)
 	if err != nil {
 		return nil, err
 	}
-	return c.SignAndDo(r)
+	if query != nil {
+		r.URL.RawQuery = query.Encode()
+	}
+	return c.doTraced(ctx, operation, r)
 }
 
-func (c *ZeroKitAdminApiClient) doSignedGet(urlPath string,
-	query url.Values) (*http.Response, error) {
-	endpoint := c.ServiceUrl
-	endpoint.Path = path.Join(endpoint.Path, urlPath)
-	r, err := http.NewRequest("GET", endpoint.String(), nil)
+// doTraced signs and sends the request within a span named after the admin
+// API operation. The trace context is injected after signing, so the
+// propagation headers are not part of the HMACHeaders list and the canonical
+// request string stays exactly as the tenant expects it.
+func (c *ZeroKitAdminApiClient) doTraced(ctx context.Context, operation string,
+	req *http.Request) (*http.Response, error) {
+	ctx, span := c.tracer.Start(ctx, operation, req.URL.Path)
+	req = req.WithContext(ctx)
+
+	resp, err := c.do(req, func(r *http.Request) {
+		c.tracer.Inject(ctx, r.Header)
+	})
 	if err != nil {
+		span.End(0, err)
 		return nil, err
 	}
-	r.URL.RawQuery = query.Encode()
-	return c.SignAndDo(r)
+	span.End(resp.StatusCode, nil)
+	return resp, nil
 }
 
+// SignAndDo signs the request with the admin key and sends it, for admin API
+// endpoints not covered by the client.
 func (c *ZeroKitAdminApiClient) SignAndDo(req *http.Request) (*http.Response, error) {
-	err := c.sign(req)
-	if err != nil {
-		return nil, err
-	}
-	return c.httpClient.Do(req)
+	return c.do(req, nil)
 }
 
+// ListTresorMembers returns the ids of the users the tresor is shared with.
 func (c *ZeroKitAdminApiClient) ListTresorMembers(tresorId string) ([]string, error) {
+	return c.ListTresorMembersContext(context.Background(), tresorId)
+}
+
+// ListTresorMembersContext is like ListTresorMembers, but carries the given
+// context with the request.
+func (c *ZeroKitAdminApiClient) ListTresorMembersContext(ctx context.Context,
+	tresorId string) ([]string, error) {
+	if c.membersCache != nil {
+		return c.membersCache.load(ctx, tresorId, c.listTresorMembers)
+	}
+	return c.listTresorMembers(ctx, tresorId)
+}
+
+func (c *ZeroKitAdminApiClient) listTresorMembers(ctx context.Context,
+	tresorId string) ([]string, error) {
 	q := url.Values{}
 	q.Add("tresorid", tresorId)
 
-	resp, err := c.doSignedGet(ListTresorMembersPath, q)
+	resp, err := call[struct{}, listTresorMembersResponse](
+		ctx, c, listTresorMembers, q, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return resp.Members, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	m := map[string][]string{}
-	err = json.Unmarshal(body, &m)
-	if err != nil {
-		return nil, err
+type listTresorMembersResponse struct {
+	Members []string `json:"Members"`
+}
+
+func (r *listTresorMembersResponse) missingFields() []string {
+	if r.Members == nil {
+		return []string{"Members"}
 	}
-	return m["Members"], nil
+	return nil
 }
 
+// InitUserRegistration creates a user and starts its registration, which
+// is completed by the user's client with the returned session.
 func (c *ZeroKitAdminApiClient) InitUserRegistration() (*UserRegistrationData, error) {
-	resp, err := c.doSignedPost(InitiateUserRegistrationPath, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return c.InitUserRegistrationContext(context.Background())
+}
 
-	var reg UserRegistrationData
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&reg)
-	if err != nil {
-		return nil, err
+// InitUserRegistrationContext is like InitUserRegistration, but carries the
+// given context with the request.
+func (c *ZeroKitAdminApiClient) InitUserRegistrationContext(
+	ctx context.Context) (*UserRegistrationData, error) {
+	reg, err := call[struct{}, UserRegistrationData](
+		ctx, c, initUserRegistration, nil, nil)
+	targets := map[string]string{}
+	if reg != nil {
+		targets["UserId"] = reg.UserId
+		targets["RegSessionId"] = reg.SessionId
 	}
-	return &reg, nil
+	return reg, c.audit(ctx, "InitUserRegistration", targets, err)
 }
 
+// UserRegistrationData is the registration session of a new user.
 type UserRegistrationData struct {
 	SessionId       string `json:"RegSessionId"`
 	SessionVerifier string `json:"RegSessionVerifier"`
 	UserId          string `json:"UserId"`
 }
 
-func (c *ZeroKitAdminApiClient) ApproveTresorCreation(tresorId string) error {
-	m := map[string]string{"TresorId": tresorId}
-	body, err := json.Marshal(m)
-	if err != nil {
-		return err
+func (r *UserRegistrationData) missingFields() []string {
+	var missing []string
+	if r.SessionId == "" {
+		missing = append(missing, "RegSessionId")
 	}
-
-	resp, err := c.doSignedPost(ApproveTresorCreationPath, body)
-	if err != nil {
-		return err
+	if r.SessionVerifier == "" {
+		missing = append(missing, "RegSessionVerifier")
 	}
-	defer resp.Body.Close()
-	return nil
+	if r.UserId == "" {
+		missing = append(missing, "UserId")
+	}
+	return missing
 }
 
-func (c *ZeroKitAdminApiClient) ValidateUserRegistration(zeroKitId, sessionId,
-	sessionVerifier, validationVerifier string) error {
-	m := orderedMap{
-		{"RegSessionId", sessionId},
-		{"RegSessionVerifier", sessionVerifier},
-		{"RegValidationVerifier", validationVerifier},
-		{"UserId", zeroKitId},
-	}
-	body, err := json.Marshal(m)
-	if err != nil {
-		return err
-	}
+// ApproveTresorCreation approves the tresor created by a user.
+func (c *ZeroKitAdminApiClient) ApproveTresorCreation(tresorId string) error {
+	return c.ApproveTresorCreationContext(context.Background(), tresorId)
+}
 
-	resp, err := c.doSignedPost(ValidateUserRegistrationPath, body)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return nil
+// ApproveTresorCreationContext is like ApproveTresorCreation, but carries the
+// given context with the request.
+func (c *ZeroKitAdminApiClient) ApproveTresorCreationContext(ctx context.Context,
+	tresorId string) error {
+	_, err := call[approveTresorCreationRequest, struct{}](
+		ctx, c, approveTresorCreation, nil,
+		&approveTresorCreationRequest{TresorId: tresorId})
+	c.InvalidateTresorMembers(tresorId)
+	return c.audit(ctx, "ApproveTresorCreation",
+		map[string]string{"TresorId": tresorId}, err)
 }
 
-// The OrderedMap struct implements json.Marshaler interface and serializes
-// a map to a JSON by preserving the order of its keys.
-type orderedMap []mapItem
+type approveTresorCreationRequest struct {
+	TresorId string `json:"TresorId"`
+}
 
-type mapItem struct {
-	key   string
-	value interface{}
+// ApproveShare approves the pending operation sharing a tresor with a user.
+func (c *ZeroKitAdminApiClient) ApproveShare(operationId string) error {
+	return c.ApproveShareContext(context.Background(), operationId)
 }
 
-func (m orderedMap) MarshalJSON() ([]byte, error) {
-	var buffer bytes.Buffer
-	buffer.WriteString("{")
-	for i, item := range m {
-		if i != 0 {
-			buffer.WriteString(",")
-		}
-		key, err := json.Marshal(item.key)
-		if err != nil {
-			return nil, err
-		}
-		buffer.Write(key)
-		buffer.WriteString(":")
-		val, err := json.Marshal(item.value)
-		if err != nil {
-			return nil, err
-		}
-		buffer.Write(val)
-	}
+// ApproveShareContext is like ApproveShare, but carries the given context
+// with the request.
+func (c *ZeroKitAdminApiClient) ApproveShareContext(ctx context.Context,
+	operationId string) error {
+	_, err := call[approveOperationRequest, struct{}](
+		ctx, c, approveShare, nil,
+		&approveOperationRequest{OperationId: operationId})
+	// the operation id does not tell which tresor has been shared
+	c.invalidateAllTresorMembers()
+	return c.audit(ctx, "ApproveShare",
+		map[string]string{"OperationId": operationId}, err)
+}
+
+// ApproveKick approves the pending operation removing a user from a tresor.
+func (c *ZeroKitAdminApiClient) ApproveKick(operationId string) error {
+	return c.ApproveKickContext(context.Background(), operationId)
+}
+
+// ApproveKickContext is like ApproveKick, but carries the given context with
+// the request.
+func (c *ZeroKitAdminApiClient) ApproveKickContext(ctx context.Context,
+	operationId string) error {
+	_, err := call[approveOperationRequest, struct{}](
+		ctx, c, approveKick, nil,
+		&approveOperationRequest{OperationId: operationId})
+	// the operation id does not tell which tresor the user has been kicked from
+	c.invalidateAllTresorMembers()
+	return c.audit(ctx, "ApproveKick",
+		map[string]string{"OperationId": operationId}, err)
+}
+
+type approveOperationRequest struct {
+	OperationId string `json:"OperationId"`
+}
+
+// ValidateUserRegistration completes the registration of a user, once the
+// user's client has finished its part of the registration.
+func (c *ZeroKitAdminApiClient) ValidateUserRegistration(zeroKitId, sessionId,
+	sessionVerifier, validationVerifier string) error {
+	return c.ValidateUserRegistrationContext(context.Background(), zeroKitId,
+		sessionId, sessionVerifier, validationVerifier)
+}
+
+// ValidateUserRegistrationContext is like ValidateUserRegistration, but
+// carries the given context with the request.
+func (c *ZeroKitAdminApiClient) ValidateUserRegistrationContext(
+	ctx context.Context, zeroKitId, sessionId, sessionVerifier,
+	validationVerifier string) error {
+	_, err := call[validateUserRegistrationRequest, struct{}](
+		ctx, c, validateUserRegistration, nil,
+		&validateUserRegistrationRequest{
+			RegSessionId:          sessionId,
+			RegSessionVerifier:    sessionVerifier,
+			RegValidationVerifier: validationVerifier,
+			UserId:                zeroKitId,
+		})
+	return c.audit(ctx, "ValidateUserRegistration",
+		map[string]string{"UserId": zeroKitId, "RegSessionId": sessionId}, err)
+}
 
-	buffer.WriteString("}")
-	return buffer.Bytes(), nil
+// The fields are serialized in the order of their declaration, which is the
+// order expected by the admin API.
+type validateUserRegistrationRequest struct {
+	RegSessionId          string `json:"RegSessionId"`
+	RegSessionVerifier    string `json:"RegSessionVerifier"`
+	RegValidationVerifier string `json:"RegValidationVerifier"`
+	UserId                string `json:"UserId"`
 }
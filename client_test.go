@@ -213,3 +213,45 @@ func TestValidateUserRegistration(t *testing.T) {
 		t.Errorf("validate user registration must not fail, was = %v", err)
 	}
 }
+
+func TestApproveShareAndKick(t *testing.T) {
+	operationId := "0000sb8s7zyx8mhyvnfb6sud"
+
+	for _, path := range []string{ApproveShareOperationPath, ApproveKickOperationPath} {
+		client := &mockHttpClient{
+			DoMock: func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path != path {
+					t.Errorf("path = %s, want = %s", req.URL.Path, path)
+				}
+				m := map[string]string{}
+				body, _ := ioutil.ReadAll(req.Body)
+				err := json.Unmarshal(body, &m)
+				if err != nil {
+					t.Errorf("invalid request's body %s", string(body))
+				}
+				if m["OperationId"] != operationId {
+					t.Errorf("OperationId = %s, want = %s",
+						m["OperationId"], operationId)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(""))),
+				}, nil
+			},
+		}
+		c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey)
+		if err != nil {
+			t.Fatal("cannot initialize tresorit client")
+		}
+		c.httpClient = client
+
+		if path == ApproveShareOperationPath {
+			err = c.ApproveShare(operationId)
+		} else {
+			err = c.ApproveKick(operationId)
+		}
+		if err != nil {
+			t.Errorf("approving %s must not fail, was = %v", path, err)
+		}
+	}
+}
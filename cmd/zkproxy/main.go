@@ -0,0 +1,195 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// zkproxy signs the admin API requests of internal services, so that they
+// can use the admin API of a ZeroKit tenant without holding the admin key.
+//
+// The callers authenticate with a client certificate or a bearer token and
+// may only use the admin endpoints allowed for them in the config:
+//
+//	listen: ":8443"
+//	tls:
+//	  cert: /etc/zkproxy/server.crt
+//	  key: /etc/zkproxy/server.key
+//	  clientCA: /etc/zkproxy/clients.crt
+//	tenant:
+//	  serviceUrl: https://example.api.tresorit.io
+//	  adminUserId: admin@example.tresorit.io
+//	  adminKeyEnv: ZEROKIT_ADMIN_KEY
+//	callers:
+//	  - name: billing
+//	    commonName: billing.internal
+//	    allow: ["GET /api/v4/admin/tresor/list-members"]
+//	  - name: signup
+//	    tokenSha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    allow: ["POST /api/v4/admin/user/init-user-registration"]
+//
+// Without tls.cert the proxy refuses to start, as the bearer tokens would be
+// sent in cleartext, unless "insecure: true" is set, e.g. behind a sidecar
+// terminating TLS.
+//
+// Every call is logged as JSON to stderr.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	Listen   string `yaml:"listen"`
+	Insecure bool   `yaml:"insecure"`
+	TLS      struct {
+		Cert     string `yaml:"cert"`
+		Key      string `yaml:"key"`
+		ClientCA string `yaml:"clientCA"`
+	} `yaml:"tls"`
+	Tenant  zerokit.TenantConfig `yaml:"tenant"`
+	Callers []callerConfig       `yaml:"callers"`
+}
+
+func main() {
+	path := flag.String("config", "zkproxy.yaml", "config file")
+	flag.Parse()
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	if err := run(*path, log); err != nil {
+		fmt.Fprintln(os.Stderr, "zkproxy:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, log *slog.Logger) error {
+	conf, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	tenant, err := url.Parse(conf.Tenant.ServiceUrl)
+	if err != nil {
+		return fmt.Errorf("invalid service URL: %w", err)
+	}
+	client, err := conf.Tenant.NewClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	handler, err := newProxy(client, *tenant, conf.Callers, log)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              conf.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	if conf.TLS.ClientCA != "" {
+		pem, err := ioutil.ReadFile(conf.TLS.ClientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", conf.TLS.ClientCA)
+		}
+		// callers without a certificate may still authenticate with a token
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		log.Info("listening", "addr", conf.Listen, "tenant", conf.Tenant.ServiceUrl)
+		if conf.TLS.Cert != "" {
+			errs <- server.ListenAndServeTLS(conf.TLS.Cert, conf.TLS.Key)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func readConfig(path string) (*config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf config
+	if err := yaml.Unmarshal(content, &conf); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if conf.Listen == "" {
+		conf.Listen = ":8443"
+	}
+	if conf.TLS.ClientCA != "" && conf.TLS.Cert == "" {
+		return nil, errors.New("tls.clientCA requires tls.cert and tls.key")
+	}
+	if conf.TLS.Cert == "" && !conf.Insecure {
+		return nil, errors.New("tls.cert and tls.key are required, unless insecure is set")
+	}
+	return &conf, nil
+}
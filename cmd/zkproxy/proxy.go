@@ -0,0 +1,215 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+// maxRequestSize is the maximum size of the body of a proxied request.
+const maxRequestSize = 1 << 20
+
+// callerConfig configures an internal caller of the proxy. It authenticates
+// either with a client certificate with the common name, or with a bearer
+// token whose SHA256 is given, so that the config does not hold the token.
+type callerConfig struct {
+	Name        string `yaml:"name"`
+	CommonName  string `yaml:"commonName,omitempty"`
+	TokenSha256 string `yaml:"tokenSha256,omitempty"`
+	// Allow lists the admin endpoints the caller may use, each as the
+	// method and the path, e.g. "GET /api/v4/admin/tresor/list-members".
+	Allow []string `yaml:"allow"`
+}
+
+type caller struct {
+	name       string
+	commonName string
+	tokenHash  []byte
+	allow      map[string]bool
+}
+
+// signer signs and sends the admin requests. It is implemented by
+// *zerokit.ZeroKitAdminApiClient.
+type signer interface {
+	SignAndDo(req *http.Request) (*http.Response, error)
+}
+
+// proxy forwards the requests of authenticated internal callers to the
+// admin API of a tenant, signed with the admin key.
+type proxy struct {
+	client  signer
+	tenant  url.URL
+	callers []caller
+	log     *slog.Logger
+}
+
+func newProxy(client signer, tenant url.URL, configs []callerConfig,
+	log *slog.Logger) (*proxy, error) {
+	p := &proxy{client: client, tenant: tenant, log: log}
+	for _, c := range configs {
+		if c.Name == "" {
+			return nil, errors.New("caller without name")
+		}
+		if c.CommonName == "" && c.TokenSha256 == "" {
+			return nil, fmt.Errorf("caller %s: neither commonName nor tokenSha256 set", c.Name)
+		}
+		cl := caller{name: c.Name, commonName: c.CommonName, allow: map[string]bool{}}
+		if c.TokenSha256 != "" {
+			hash, err := hex.DecodeString(c.TokenSha256)
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("caller %s: tokenSha256 is not a hex encoded SHA256", c.Name)
+			}
+			cl.tokenHash = hash
+		}
+		for _, endpoint := range c.Allow {
+			fields := strings.Fields(endpoint)
+			if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+				return nil, fmt.Errorf("caller %s: invalid endpoint %q", c.Name, endpoint)
+			}
+			cl.allow[strings.ToUpper(fields[0])+" "+fields[1]] = true
+		}
+		p.callers = append(p.callers, cl)
+	}
+	return p, nil
+}
+
+// identify returns the caller authenticated by the verified client
+// certificate or the bearer token of the request, if any.
+func (p *proxy) identify(r *http.Request) *caller {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for i := range p.callers {
+			if p.callers[i].commonName != "" && p.callers[i].commonName == cn {
+				return &p.callers[i]
+			}
+		}
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+	for i := range p.callers {
+		if p.callers[i].tokenHash != nil &&
+			subtle.ConstantTimeCompare(sum[:], p.callers[i].tokenHash) == 1 {
+			return &p.callers[i]
+		}
+	}
+	return nil
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status, name, err := p.forward(w, r)
+	attrs := []any{
+		"caller", name,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	p.log.Info("admin request", attrs...)
+}
+
+// forward forwards the request and writes the response. It returns the
+// status code written, the name of the caller and the error, if any.
+func (p *proxy) forward(w http.ResponseWriter, r *http.Request) (int, string, error) {
+	c := p.identify(r)
+	if c == nil {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return http.StatusUnauthorized, "", nil
+	}
+	if !c.allow[r.Method+" "+r.URL.Path] {
+		http.Error(w, "endpoint not allowed", http.StatusForbidden)
+		return http.StatusForbidden, c.name, nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return http.StatusRequestEntityTooLarge, c.name, err
+	}
+	target := p.tenant
+	target.Path = path.Join(target.Path, r.URL.Path)
+	target.RawQuery = r.URL.RawQuery
+	var content io.Reader
+	if len(body) > 0 {
+		content = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), content)
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return http.StatusBadRequest, c.name, err
+	}
+
+	resp, err := p.client.SignAndDo(req)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, zerokit.ErrCircuitOpen):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, http.StatusText(status), status)
+		return status, c.name, err
+	}
+	defer resp.Body.Close()
+
+	for _, key := range []string{"Content-Type", "Retry-After"} {
+		if value := resp.Header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	return resp.StatusCode, c.name, err
+}
@@ -0,0 +1,215 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+const (
+	membersPath  = "/api/v4/admin/tresor/list-members"
+	approvePath  = "/api/v4/admin/tresor/approve-tresor-creation"
+	callerToken  = "s3cret-token"
+	adminKey     = "204bcf1b"
+	adminUserId  = "admin@exampletenant.tresorit.io"
+	callerCommon = "billing.internal"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestProxy(t *testing.T) (*proxy, *bytes.Buffer, *[]*http.Request) {
+	var received []*http.Request
+	tenant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Members":["zk1"]}`))
+	}))
+	t.Cleanup(tenant.Close)
+
+	client, err := zerokit.NewZeroKitAdminApiClient(tenant.URL, adminUserId, adminKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(tenant.URL)
+	logs := &bytes.Buffer{}
+	p, err := newProxy(client, *u, []callerConfig{
+		{
+			Name:        "signup",
+			TokenSha256: tokenHash(callerToken),
+			Allow:       []string{"GET " + membersPath},
+		},
+		{
+			Name:       "billing",
+			CommonName: callerCommon,
+			Allow:      []string{"post " + approvePath},
+		},
+	}, slog.New(slog.NewJSONHandler(logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, logs, &received
+}
+
+func TestProxySignsAllowedRequests(t *testing.T) {
+	p, logs, received := newTestProxy(t)
+
+	req := httptest.NewRequest("GET", membersPath+"?tresorid=xyz", nil)
+	req.Header.Set("Authorization", "Bearer "+callerToken)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rec.Code, http.StatusOK)
+	}
+	if body := rec.Body.String(); body != `{"Members":["zk1"]}` {
+		t.Errorf("body = %s", body)
+	}
+	if len(*received) != 1 {
+		t.Fatalf("requests forwarded = %d, want = 1", len(*received))
+	}
+	forwarded := (*received)[0]
+	if auth := forwarded.Header.Get("Authorization"); !strings.HasPrefix(auth, "AdminKey ") {
+		t.Errorf("forwarded Authorization = %q, want an AdminKey signature", auth)
+	}
+	if forwarded.URL.Path != membersPath || forwarded.URL.Query().Get("tresorid") != "xyz" {
+		t.Errorf("forwarded URL = %s", forwarded.URL)
+	}
+
+	if !strings.Contains(logs.String(), `"caller":"signup"`) {
+		t.Errorf("log does not name the caller: %s", logs)
+	}
+	if strings.Contains(logs.String(), callerToken) {
+		t.Errorf("log contains the token: %s", logs)
+	}
+}
+
+func TestProxyClientCertificate(t *testing.T) {
+	p, _, received := newTestProxy(t)
+
+	req := httptest.NewRequest("POST", approvePath, strings.NewReader(`{"TresorId":"xyz"}`))
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: callerCommon}},
+	}}}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want = %d", rec.Code, http.StatusOK)
+	}
+	if len(*received) != 1 || (*received)[0].Method != "POST" {
+		t.Errorf("request not forwarded as POST")
+	}
+}
+
+func TestProxyRejectsRequests(t *testing.T) {
+	p, _, received := newTestProxy(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{"no credentials", "GET", membersPath, "", http.StatusUnauthorized},
+		{"wrong token", "GET", membersPath, "Bearer wrong", http.StatusUnauthorized},
+		{"not allowed path", "POST", approvePath, "Bearer " + callerToken, http.StatusForbidden},
+		{"not allowed method", "POST", membersPath, "Bearer " + callerToken, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.auth != "" {
+				req.Header.Set("Authorization", test.auth)
+			}
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want = %d", rec.Code, test.status)
+			}
+		})
+	}
+	if len(*received) != 0 {
+		t.Errorf("requests forwarded = %d, want = 0", len(*received))
+	}
+}
+
+func TestNewProxyValidatesCallers(t *testing.T) {
+	tests := []callerConfig{
+		{TokenSha256: tokenHash(callerToken)},
+		{Name: "a"},
+		{Name: "a", TokenSha256: "abc"},
+		{Name: "a", CommonName: "a", Allow: []string{"/no-method"}},
+	}
+	for _, test := range tests {
+		if _, err := newProxy(nil, url.URL{}, []callerConfig{test}, slog.Default()); err == nil {
+			t.Errorf("caller %+v must be rejected", test)
+		}
+	}
+}
+
+func TestReadConfigRequiresTLS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zkproxy.yaml")
+	plain := "tenant:\n  serviceUrl: https://example.api.tresorit.io\n"
+
+	ioutil.WriteFile(path, []byte(plain), 0600)
+	if _, err := readConfig(path); err == nil {
+		t.Error("config without TLS must be rejected")
+	}
+
+	ioutil.WriteFile(path, []byte(plain+"insecure: true\n"), 0600)
+	if _, err := readConfig(path); err != nil {
+		t.Errorf("insecure config must be accepted, was = %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte(plain+"tls:\n  cert: server.crt\n  key: server.key\n"), 0600)
+	if _, err := readConfig(path); err != nil {
+		t.Errorf("config with TLS must be accepted, was = %v", err)
+	}
+}
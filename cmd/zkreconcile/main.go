@@ -0,0 +1,143 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// zkreconcile compares the desired members of ZeroKit tresors with their
+// actual members and prints the differences as JSON.
+//
+// The desired members are read from a JSON file mapping tresor ids to user
+// ids:
+//
+//	{"tresor1": ["zk1", "zk2"], "tresor2": ["zk3"]}
+//
+// The exit status is 0 if all tresors are in sync, 2 if any differ and 1 on
+// errors.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+func main() {
+	var (
+		serviceUrl  = flag.String("service-url", "", "service URL of the tenant")
+		adminUserId = flag.String("admin-user-id", "", "admin user id of the tenant")
+		adminKeyEnv = flag.String("admin-key-env", "ZEROKIT_ADMIN_KEY",
+			"environment variable holding the admin key")
+		config = flag.String("config", "",
+			"tenants config file, used instead of -service-url and -admin-user-id")
+		tenant      = flag.String("tenant", "", "tenant of the config file")
+		desired     = flag.String("desired", "-", "desired members file, - for stdin")
+		concurrency = flag.Int("concurrency", 4, "tresors listed at the same time")
+	)
+	flag.Parse()
+
+	inSync, err := run(*serviceUrl, *adminUserId, *adminKeyEnv, *config, *tenant,
+		*desired, *concurrency)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zkreconcile:", err)
+		os.Exit(1)
+	}
+	if !inSync {
+		os.Exit(2)
+	}
+}
+
+func run(serviceUrl, adminUserId, adminKeyEnv, config, tenant, desiredPath string,
+	concurrency int) (bool, error) {
+	client, err := newClient(serviceUrl, adminUserId, adminKeyEnv, config, tenant)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	desired, err := readDesired(desiredPath)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := client.ReconcileTresorMembers(ctx, desired, concurrency)
+	if err != nil {
+		return false, err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return false, err
+	}
+	return report.InSync(), nil
+}
+
+func newClient(serviceUrl, adminUserId, adminKeyEnv, config,
+	tenant string) (*zerokit.ZeroKitAdminApiClient, error) {
+	if config != "" {
+		registry, err := zerokit.NewClientRegistryFromFile(config)
+		if err != nil {
+			return nil, err
+		}
+		return registry.Client(tenant)
+	}
+	keys, err := zerokit.NewEnvKeyProvider(adminKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	return zerokit.NewZeroKitAdminApiClientWithKeyProvider(serviceUrl,
+		adminUserId, keys)
+}
+
+func readDesired(path string) (map[string][]string, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if path == "-" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var desired map[string][]string
+	if err := json.Unmarshal(content, &desired); err != nil {
+		return nil, fmt.Errorf("invalid desired members %s: %w", path, err)
+	}
+	return desired, nil
+}
@@ -0,0 +1,127 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookEmitter sends signed event callbacks like a tenant does. It lets
+// services test their WebhookHandler locally.
+type WebhookEmitter struct {
+	url        string
+	signer     *requestSigner
+	httpClient HttpClient
+}
+
+// NewWebhookEmitter creates an emitter sending the callbacks to url, signed
+// with the admin key of adminUserId supplied by keys. The callbacks are sent
+// with http.DefaultClient, unless httpClient is set.
+func NewWebhookEmitter(url, adminUserId string, keys KeyProvider,
+	httpClient HttpClient) *WebhookEmitter {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &WebhookEmitter{
+		url:        url,
+		signer:     &requestSigner{keys: keys, adminUserId: adminUserId},
+		httpClient: httpClient,
+	}
+}
+
+// Emit sends the event. An EventId and Time left empty are filled in. A
+// callback which is not acknowledged with 2xx fails with an *APIError.
+func (e *WebhookEmitter) Emit(ctx context.Context, event Event) error {
+	meta := event.meta()
+	if meta.EventId == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		meta.EventId = hex.EncodeToString(id)
+	}
+	if meta.Time.IsZero() {
+		meta.Time = time.Now().UTC()
+	}
+
+	var data json.RawMessage
+	if raw, ok := event.(*RawEvent); ok {
+		data = raw.Data
+	} else {
+		var err error
+		data, err = json.Marshal(event)
+		if err != nil {
+			return err
+		}
+	}
+	return e.emit(ctx, eventEnvelope{
+		EventId:   meta.EventId,
+		EventType: event.EventType(),
+		Time:      meta.Time,
+		Data:      data,
+	})
+}
+
+func (e *WebhookEmitter) emit(ctx context.Context, envelope eventEnvelope) error {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if err := e.signer.sign(req); err != nil {
+		return err
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxEventSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{
+			Operation:  "Emit" + string(envelope.EventType),
+			StatusCode: resp.StatusCode,
+			Body:       content,
+		}
+	}
+	return nil
+}
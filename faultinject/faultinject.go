@@ -0,0 +1,256 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package faultinject wraps the HTTP client of a ZeroKit admin API client
+// and injects faults into its exchanges with the tenant, so that retries,
+// fallbacks and circuit breaking can be tested against a real or a fake
+// tenant.
+//
+// Faults are injected either by a script, which decides the fate of the
+// first requests in order, or by chance, each fault having a probability.
+package faultinject
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Doer sends HTTP requests. It is implemented by *http.Client and by the
+// Transport of this package.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Fault handles a request in place of the wrapped Doer, which it may call.
+type Fault func(req *http.Request, next Doer) (*http.Response, error)
+
+// Pass sends the request unchanged. It is used in scripts for the requests
+// which should succeed.
+func Pass() Fault {
+	return nil
+}
+
+// Latency delays the request by d, unless its context is done first.
+func Latency(d time.Duration) Fault {
+	return func(req *http.Request, next Doer) (*http.Response, error) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		return next.Do(req)
+	}
+}
+
+// ConnectionReset fails the request like a connection reset by the peer.
+// The request does not reach the tenant.
+func ConnectionReset() Fault {
+	return func(req *http.Request, next Doer) (*http.Response, error) {
+		return nil, &net.OpError{
+			Op:  "read",
+			Net: "tcp",
+			Err: os.NewSyscallError("read", syscall.ECONNRESET),
+		}
+	}
+}
+
+// ServerError responds with the given 5xx status code. The request does not
+// reach the tenant.
+func ServerError(statusCode int) Fault {
+	return func(req *http.Request, next Doer) (*http.Response, error) {
+		return errorResponse(req, statusCode, "InternalServerError",
+			"injected server error"), nil
+	}
+}
+
+// TooManyRequests responds with 429 and a Retry-After header of the given
+// duration, rounded up to whole seconds. The request does not reach the
+// tenant.
+func TooManyRequests(retryAfter time.Duration) Fault {
+	return func(req *http.Request, next Doer) (*http.Response, error) {
+		resp := errorResponse(req, http.StatusTooManyRequests, "TooManyRequests",
+			"injected rate limit")
+		seconds := (retryAfter + time.Second - 1) / time.Second
+		resp.Header.Set("Retry-After", strconv.Itoa(int(seconds)))
+		return resp, nil
+	}
+}
+
+// SignatureRejection responds with 401, as the tenant does for requests
+// signed with a revoked admin key. The request does not reach the tenant.
+func SignatureRejection() Fault {
+	return func(req *http.Request, next Doer) (*http.Response, error) {
+		return errorResponse(req, http.StatusUnauthorized, "Unauthorized",
+			"injected signature rejection"), nil
+	}
+}
+
+// TruncatedBody sends the request, but cuts the body of the response in
+// half, like a connection closed while the response is read.
+func TruncatedBody() Fault {
+	return func(req *http.Request, next Doer) (*http.Response, error) {
+		resp, err := next.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = body[:len(body)/2]
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+		return resp, nil
+	}
+}
+
+func errorResponse(req *http.Request, statusCode int, code,
+	message string) *http.Response {
+	body := fmt.Sprintf(`{"ErrorCode":%q,"ErrorMessage":%q}`, code, message)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type chance struct {
+	probability float64
+	fault       Fault
+}
+
+// Transport is a Doer injecting faults into the requests sent by the
+// wrapped Doer. It is safe for concurrent use.
+type Transport struct {
+	next Doer
+
+	mu       sync.Mutex
+	script   []Fault
+	chances  []chance
+	rand     *rand.Rand
+	injected int
+}
+
+// Option configures a Transport.
+type Option func(*Transport)
+
+// WithScript sets the faults injected into the first requests, one per
+// request and in order. Once the script is exhausted, faults are injected by
+// chance.
+func WithScript(faults ...Fault) Option {
+	return func(t *Transport) {
+		t.script = append(t.script, faults...)
+	}
+}
+
+// WithProbability injects the fault into the given fraction of the
+// requests. The probabilities of several faults are rolled in the order of
+// the options, and the first fault hit is injected.
+func WithProbability(probability float64, fault Fault) Option {
+	return func(t *Transport) {
+		t.chances = append(t.chances, chance{probability, fault})
+	}
+}
+
+// WithSeed seeds the random numbers deciding which faults are injected, so
+// that a test run can be repeated.
+func WithSeed(seed int64) Option {
+	return func(t *Transport) {
+		t.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// New creates a Transport sending the requests with next.
+func New(next Doer, opts ...Option) *Transport {
+	t := &Transport{
+		next: next,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Do sends the request, unless a fault is injected in its place.
+func (t *Transport) Do(req *http.Request) (*http.Response, error) {
+	if fault := t.pick(); fault != nil {
+		return fault(req, t.next)
+	}
+	return t.next.Do(req)
+}
+
+// Injected returns the number of faults injected so far.
+func (t *Transport) Injected() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.injected
+}
+
+// pick returns the fault to inject into the next request, if any.
+func (t *Transport) pick() Fault {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var fault Fault
+	if len(t.script) > 0 {
+		fault, t.script = t.script[0], t.script[1:]
+	} else {
+		for _, c := range t.chances {
+			if t.rand.Float64() < c.probability {
+				fault = c.fault
+				break
+			}
+		}
+	}
+	if fault != nil {
+		t.injected++
+	}
+	return fault
+}
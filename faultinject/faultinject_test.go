@@ -0,0 +1,180 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package faultinject
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+type tenantFunc func(req *http.Request) (*http.Response, error)
+
+func (f tenantFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// tenant answers every request with two tresor members.
+var tenant = tenantFunc(func(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(`{"Members":["zk1","zk2"]}`)),
+	}, nil
+})
+
+func newRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest("GET", "https://example.api.tresorit.io/api/v4/admin/tresor/list-members", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestScript(t *testing.T) {
+	tr := New(tenant, WithScript(
+		ServerError(http.StatusServiceUnavailable),
+		ConnectionReset(),
+		TooManyRequests(1500*time.Millisecond),
+		SignatureRejection(),
+		Pass(),
+	))
+
+	resp, err := tr.Do(newRequest(t))
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %v, %v", resp, err)
+	}
+	_, err = tr.Do(newRequest(t))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected connection reset, got %v", err)
+	}
+	resp, err = tr.Do(newRequest(t))
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests ||
+		resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected 429 with Retry-After 2, got %v, %v", resp, err)
+	}
+	resp, err = tr.Do(newRequest(t))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %v, %v", resp, err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err = tr.Do(newRequest(t))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("expected request to pass, got %v, %v", resp, err)
+		}
+	}
+	if n := tr.Injected(); n != 4 {
+		t.Errorf("expected 4 faults to be injected, got %d", n)
+	}
+}
+
+func TestProbability(t *testing.T) {
+	tr := New(tenant, WithSeed(1),
+		WithProbability(0.3, ServerError(http.StatusInternalServerError)))
+
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		resp, err := tr.Do(newRequest(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusInternalServerError {
+			failed++
+		}
+	}
+	if failed < 250 || failed > 350 {
+		t.Errorf("expected about 300 failed requests, got %d", failed)
+	}
+	if n := tr.Injected(); n != failed {
+		t.Errorf("expected %d faults to be injected, got %d", failed, n)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	tr := New(tenant, WithProbability(1, Latency(time.Hour)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := tr.Do(newRequest(t).WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	tr = New(tenant, WithProbability(1, Latency(10*time.Millisecond)))
+	start := time.Now()
+	resp, err := tr.Do(newRequest(t))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected request to pass, got %v, %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected request to be delayed, took %v", elapsed)
+	}
+}
+
+func TestTruncatedBody(t *testing.T) {
+	c, err := zerokit.NewZeroKitAdminApiClient("https://example.api.tresorit.io",
+		"admin@example.tresorit.io", "204bcf1b",
+		zerokit.WithHttpClient(New(tenant, WithScript(TruncatedBody()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListTresorMembers("tresor1"); err == nil {
+		t.Error("expected truncated response to be rejected")
+	}
+	if members, err := c.ListTresorMembers("tresor1"); err != nil || len(members) != 2 {
+		t.Errorf("expected second request to pass, got %v, %v", members, err)
+	}
+}
+
+func TestSignatureRejection(t *testing.T) {
+	rotated := false
+	c, err := zerokit.NewZeroKitAdminApiClient("https://example.api.tresorit.io",
+		"admin@example.tresorit.io", "204bcf1b",
+		zerokit.WithSecondaryAdminKey("a1b2c3d4e5f6"),
+		zerokit.WithKeyRotationHandler(func(zerokit.KeyRotationEvent) { rotated = true }),
+		zerokit.WithHttpClient(New(tenant, WithScript(SignatureRejection()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListTresorMembers("tresor1"); err != nil {
+		t.Fatal(err)
+	}
+	if !rotated {
+		t.Error("expected the secondary admin key to be promoted")
+	}
+}
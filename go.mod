@@ -0,0 +1,20 @@
+module github.com/gesundheitscloud/go-zerokit-api-client
+
+go 1.21
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	go.etcd.io/bbolt v1.3.10
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,216 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidId is returned for identifiers not in the format of the admin
+// API.
+var ErrInvalidId = errors.New("invalid id")
+
+// zeroKitIdPattern matches the ids of users and tresors, e.g.
+// 0000slpj4r86xbqlg9wmjhug.
+var zeroKitIdPattern = regexp.MustCompile(`^[0-9a-z]{24}$`)
+
+// UserId is the ZeroKit id of a user.
+type UserId string
+
+// ParseUserId returns the user id, if it is valid.
+func ParseUserId(s string) (UserId, error) {
+	id := UserId(s)
+	return id, id.Validate()
+}
+
+// Validate reports whether the id is in the format of ZeroKit user ids.
+func (id UserId) Validate() error {
+	return validateId("user", string(id), zeroKitIdPattern)
+}
+
+// TresorId is the ZeroKit id of a tresor.
+type TresorId string
+
+// ParseTresorId returns the tresor id, if it is valid.
+func ParseTresorId(s string) (TresorId, error) {
+	id := TresorId(s)
+	return id, id.Validate()
+}
+
+// Validate reports whether the id is in the format of ZeroKit tresor ids.
+func (id TresorId) Validate() error {
+	return validateId("tresor", string(id), zeroKitIdPattern)
+}
+
+// RegSessionId is the id of a user registration session.
+type RegSessionId string
+
+// Validate reports whether the id is set. The format of session ids is not
+// specified by the admin API.
+func (id RegSessionId) Validate() error {
+	return validateId("registration session", string(id), nil)
+}
+
+// OperationId is the id of a pending share or kick operation.
+type OperationId string
+
+// Validate reports whether the id is set. The format of operation ids is not
+// specified by the admin API.
+func (id OperationId) Validate() error {
+	return validateId("operation", string(id), nil)
+}
+
+func validateId(kind, id string, pattern *regexp.Regexp) error {
+	if id == "" || pattern != nil && !pattern.MatchString(id) {
+		return fmt.Errorf("%w: %s id %q", ErrInvalidId, kind, id)
+	}
+	return nil
+}
+
+// ValidateUserRegistrationRequest completes the registration of a user. The
+// verifiers are secrets and must not be logged.
+type ValidateUserRegistrationRequest struct {
+	UserId                UserId
+	RegSessionId          RegSessionId
+	RegSessionVerifier    string
+	RegValidationVerifier string
+}
+
+// Validate reports whether the ids are valid and the verifiers are set.
+func (r *ValidateUserRegistrationRequest) Validate() error {
+	if err := r.UserId.Validate(); err != nil {
+		return err
+	}
+	if err := r.RegSessionId.Validate(); err != nil {
+		return err
+	}
+	if r.RegSessionVerifier == "" || r.RegValidationVerifier == "" {
+		return errors.New("registration session and validation verifiers are required")
+	}
+	return nil
+}
+
+// ValidateUser is like ValidateUserRegistration, but takes the arguments as
+// a request, so that they cannot be transposed. The request is validated
+// before it is sent.
+func (c *ZeroKitAdminApiClient) ValidateUser(req ValidateUserRegistrationRequest) error {
+	return c.ValidateUserContext(context.Background(), req)
+}
+
+// ValidateUserContext is like ValidateUser, but carries the given context
+// with the request.
+func (c *ZeroKitAdminApiClient) ValidateUserContext(ctx context.Context,
+	req ValidateUserRegistrationRequest) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	return c.ValidateUserRegistrationContext(ctx, string(req.UserId),
+		string(req.RegSessionId), req.RegSessionVerifier, req.RegValidationVerifier)
+}
+
+// ListMembers is like ListTresorMembers, but takes and returns typed ids.
+// The tresor id is validated before the request is sent.
+func (c *ZeroKitAdminApiClient) ListMembers(tresorId TresorId) ([]UserId, error) {
+	return c.ListMembersContext(context.Background(), tresorId)
+}
+
+// ListMembersContext is like ListMembers, but carries the given context with
+// the request.
+func (c *ZeroKitAdminApiClient) ListMembersContext(ctx context.Context,
+	tresorId TresorId) ([]UserId, error) {
+	if err := tresorId.Validate(); err != nil {
+		return nil, err
+	}
+	members, err := c.ListTresorMembersContext(ctx, string(tresorId))
+	if err != nil {
+		return nil, err
+	}
+	return toUserIds(members), nil
+}
+
+func toUserIds(members []string) []UserId {
+	ids := make([]UserId, len(members))
+	for i, member := range members {
+		ids[i] = UserId(member)
+	}
+	return ids
+}
+
+// ApproveTresor is like ApproveTresorCreation, but takes a typed id, which
+// is validated before the request is sent.
+func (c *ZeroKitAdminApiClient) ApproveTresor(tresorId TresorId) error {
+	return c.ApproveTresorContext(context.Background(), tresorId)
+}
+
+// ApproveTresorContext is like ApproveTresor, but carries the given context
+// with the request.
+func (c *ZeroKitAdminApiClient) ApproveTresorContext(ctx context.Context,
+	tresorId TresorId) error {
+	if err := tresorId.Validate(); err != nil {
+		return err
+	}
+	return c.ApproveTresorCreationContext(ctx, string(tresorId))
+}
+
+// ApproveShareOperation is like ApproveShare, but takes a typed id, which is
+// validated before the request is sent.
+func (c *ZeroKitAdminApiClient) ApproveShareOperation(operationId OperationId) error {
+	return c.ApproveShareOperationContext(context.Background(), operationId)
+}
+
+// ApproveShareOperationContext is like ApproveShareOperation, but carries the
+// given context with the request.
+func (c *ZeroKitAdminApiClient) ApproveShareOperationContext(ctx context.Context,
+	operationId OperationId) error {
+	if err := operationId.Validate(); err != nil {
+		return err
+	}
+	return c.ApproveShareContext(ctx, string(operationId))
+}
+
+// ApproveKickOperation is like ApproveKick, but takes a typed id, which is
+// validated before the request is sent.
+func (c *ZeroKitAdminApiClient) ApproveKickOperation(operationId OperationId) error {
+	return c.ApproveKickOperationContext(context.Background(), operationId)
+}
+
+// ApproveKickOperationContext is like ApproveKickOperation, but carries the
+// given context with the request.
+func (c *ZeroKitAdminApiClient) ApproveKickOperationContext(ctx context.Context,
+	operationId OperationId) error {
+	if err := operationId.Validate(); err != nil {
+		return err
+	}
+	return c.ApproveKickContext(ctx, string(operationId))
+}
@@ -0,0 +1,162 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestParseIds(t *testing.T) {
+	for _, s := range []string{"0000slpj4r86xbqlg9wmjhug", "0000t8k47k21ozyqlxtq1bd8"} {
+		if _, err := ParseUserId(s); err != nil {
+			t.Errorf("user id %s must be valid, was = %v", s, err)
+		}
+		if _, err := ParseTresorId(s); err != nil {
+			t.Errorf("tresor id %s must be valid, was = %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "zk", "0000SLPJ4R86XBQLG9WMJHUG", "0000slpj4r86xbqlg9wmjhug0",
+		"0000slpj4r86-bqlg9wmjhug"} {
+		if _, err := ParseUserId(s); !errors.Is(err, ErrInvalidId) {
+			t.Errorf("user id %q: err = %v, want = %v", s, err, ErrInvalidId)
+		}
+		if _, err := ParseTresorId(s); !errors.Is(err, ErrInvalidId) {
+			t.Errorf("tresor id %q: err = %v, want = %v", s, err, ErrInvalidId)
+		}
+	}
+	if err := RegSessionId("").Validate(); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("empty session id: err = %v, want = %v", err, ErrInvalidId)
+	}
+	if err := OperationId("op").Validate(); err != nil {
+		t.Errorf("operation id must be valid, was = %v", err)
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	var sent validateUserRegistrationRequest
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &sent); err != nil {
+			t.Errorf("malformed request's body %v", err)
+		}
+		return response(http.StatusOK, ""), nil
+	})
+
+	err := c.ValidateUser(ValidateUserRegistrationRequest{
+		UserId:                "0000slpj4r86xbqlg9wmjhug",
+		RegSessionId:          "session",
+		RegSessionVerifier:    "verifier",
+		RegValidationVerifier: "validation",
+	})
+	if err != nil {
+		t.Fatalf("validate user must not fail, was = %v", err)
+	}
+	want := validateUserRegistrationRequest{
+		RegSessionId:          "session",
+		RegSessionVerifier:    "verifier",
+		RegValidationVerifier: "validation",
+		UserId:                "0000slpj4r86xbqlg9wmjhug",
+	}
+	if sent != want {
+		t.Errorf("request = %+v, want = %+v", sent, want)
+	}
+}
+
+func TestValidateUserInvalidRequest(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		t.Error("invalid request must not be sent")
+		return response(http.StatusOK, ""), nil
+	})
+
+	for _, req := range []ValidateUserRegistrationRequest{
+		{UserId: "zk", RegSessionId: "session", RegSessionVerifier: "v", RegValidationVerifier: "v"},
+		{UserId: "0000slpj4r86xbqlg9wmjhug", RegSessionVerifier: "v", RegValidationVerifier: "v"},
+		{UserId: "0000slpj4r86xbqlg9wmjhug", RegSessionId: "session", RegSessionVerifier: "v"},
+	} {
+		if err := c.ValidateUser(req); err == nil {
+			t.Errorf("request %+v must be rejected", req)
+		}
+	}
+}
+
+func TestTypedOperations(t *testing.T) {
+	var paths []string
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.Path)
+		return response(http.StatusOK, `{"Members":["0000slpj4r86xbqlg9wmjhug"]}`), nil
+	})
+
+	members, err := c.ListMembers("0000t8k47k21ozyqlxtq1bd8")
+	if err != nil || len(members) != 1 || members[0] != "0000slpj4r86xbqlg9wmjhug" {
+		t.Errorf("members = %v, %v", members, err)
+	}
+	if err := c.ApproveTresor("0000t8k47k21ozyqlxtq1bd8"); err != nil {
+		t.Errorf("approve tresor must not fail, was = %v", err)
+	}
+	if err := c.ApproveShareOperation("share"); err != nil {
+		t.Errorf("approve share must not fail, was = %v", err)
+	}
+	if err := c.ApproveKickOperation("kick"); err != nil {
+		t.Errorf("approve kick must not fail, was = %v", err)
+	}
+	want := []string{ListTresorMembersPath, ApproveTresorCreationPath,
+		ApproveShareOperationPath, ApproveKickOperationPath}
+	if len(paths) != len(want) {
+		t.Fatalf("paths = %v, want = %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("paths = %v, want = %v", paths, want)
+			break
+		}
+	}
+
+	paths = nil
+	if _, err := c.ListMembers("zk"); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidId)
+	}
+	if err := c.ApproveTresor(""); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidId)
+	}
+	if err := c.ApproveShareOperation(""); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidId)
+	}
+	if err := c.ApproveKickOperation(""); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidId)
+	}
+	if len(paths) != 0 {
+		t.Errorf("invalid ids must not be sent, was = %v", paths)
+	}
+}
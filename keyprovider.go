@@ -0,0 +1,328 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned when loading or using an admin key.
+var (
+	ErrInvalidAdminKey = errors.New("admin key is not a valid hex string")
+	ErrAdminKeyWiped   = errors.New("admin key has been wiped")
+)
+
+// KeyProvider supplies the binary tenant admin key used to sign the requests,
+// i.e. the hex decoded admin key shown in the management portal. It is
+// consulted for every request, so implementations may return a different key
+// after the admin key has been rotated. The returned slice is owned by the
+// provider and must not be modified. The providers of this package return a
+// copy of their key, which the client zeroes after use.
+type KeyProvider interface {
+	AdminKey() ([]byte, error)
+}
+
+// Wiper is implemented by the key providers which can overwrite the admin
+// key kept in memory. ZeroKitAdminApiClient.Close wipes the keys of the
+// client's providers.
+type Wiper interface {
+	Wipe()
+}
+
+// decodeAdminKey decodes the hex encoded admin key.
+func decodeAdminKey(key string) ([]byte, error) {
+	if key == "" {
+		return nil, ErrInvalidAdminKey
+	}
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, ErrInvalidAdminKey
+	}
+	return b, nil
+}
+
+// decodeAdminKeyBytes is like decodeAdminKey, but zeroes the hex encoded
+// input, so that only the decoded key remains in memory.
+func decodeAdminKeyBytes(key []byte) ([]byte, error) {
+	defer wipe(key)
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, ErrInvalidAdminKey
+	}
+	b := make([]byte, hex.DecodedLen(len(key)))
+	if _, err := hex.Decode(b, key); err != nil {
+		return nil, ErrInvalidAdminKey
+	}
+	return b, nil
+}
+
+// keyCopier is implemented by the providers of this package. Their AdminKey
+// returns a copy of the key, so that a concurrent Wipe or rotation cannot zero
+// a key while a request is being signed with it.
+type keyCopier interface {
+	copiesAdminKey()
+}
+
+// useAdminKey calls use with the admin key supplied by keys and zeroes the
+// key afterwards if it is a copy.
+func useAdminKey(keys KeyProvider, use func(key []byte)) error {
+	key, err := keys.AdminKey()
+	if err != nil {
+		return err
+	}
+	use(key)
+	if _, ok := keys.(keyCopier); ok {
+		wipe(key)
+	}
+	return nil
+}
+
+func copyKey(key []byte) []byte {
+	return append([]byte(nil), key...)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// staticKey is the admin key passed to NewZeroKitAdminApiClient. It is kept
+// as a pointer, so that providers remain comparable.
+type staticKey struct {
+	mu  sync.RWMutex
+	key []byte
+}
+
+func newStaticKey(adminKey string) (*staticKey, error) {
+	key, err := decodeAdminKey(adminKey)
+	if err != nil {
+		return nil, err
+	}
+	return &staticKey{key: key}, nil
+}
+
+func (k *staticKey) AdminKey() ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.key == nil {
+		return nil, ErrAdminKeyWiped
+	}
+	return copyKey(k.key), nil
+}
+
+func (*staticKey) copiesAdminKey() {}
+
+func (k *staticKey) Wipe() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	wipe(k.key)
+	k.key = nil
+}
+
+// EnvKeyProvider reads the admin key from an environment variable. As the
+// environment holds the key for the lifetime of the process anyway, it is
+// decoded on every call instead of being kept in a second place.
+type EnvKeyProvider struct {
+	name string
+}
+
+// NewEnvKeyProvider creates a provider reading the admin key from the
+// environment variable name, which must be set.
+func NewEnvKeyProvider(name string) (*EnvKeyProvider, error) {
+	p := &EnvKeyProvider{name: name}
+	if err := useAdminKey(p, func([]byte) {}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AdminKey returns the decoded admin key.
+func (p *EnvKeyProvider) AdminKey() ([]byte, error) {
+	key := strings.TrimSpace(os.Getenv(p.name))
+	if key == "" {
+		return nil, errors.New(fmt.Sprintf("environment variable %s is not set", p.name))
+	}
+	return decodeAdminKey(key)
+}
+
+func (*EnvKeyProvider) copiesAdminKey() {}
+
+// FileKeyProvider reads the admin key from a file, e.g. a mounted Kubernetes
+// secret. The file is read again whenever its modification time or size
+// changes, so a rotated key is picked up without restarting the service.
+type FileKeyProvider struct {
+	path string
+
+	mu      sync.Mutex
+	key     []byte
+	modTime time.Time
+	size    int64
+}
+
+// NewFileKeyProvider creates a provider reading the admin key from the file
+// at path.
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{path: path}
+	if err := useAdminKey(p, func([]byte) {}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AdminKey returns the decoded admin key, reading the file again if it has
+// changed.
+func (p *FileKeyProvider) AdminKey() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// os.Stat follows symlinks, which is how Kubernetes swaps the contents
+	// of a mounted secret.
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	if p.key != nil && fi.ModTime().Equal(p.modTime) && fi.Size() == p.size {
+		return copyKey(p.key), nil
+	}
+
+	content, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeAdminKeyBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	wipe(p.key)
+	p.key, p.modTime, p.size = key, fi.ModTime(), fi.Size()
+	return copyKey(key), nil
+}
+
+func (*FileKeyProvider) copiesAdminKey() {}
+
+// Wipe zeroes the admin key kept in memory. It is read from the file again
+// by the next call to AdminKey.
+func (p *FileKeyProvider) Wipe() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wipe(p.key)
+	p.key = nil
+}
+
+// SecretFunc fetches the admin key from a secret manager, e.g. Vault or a
+// cloud provider's secret store.
+type SecretFunc func() (string, error)
+
+// SecretKeyProvider caches the admin key fetched by a SecretFunc and fetches
+// it again once the refresh interval has elapsed. If fetching it again fails,
+// the cached key is used until the next refresh interval has elapsed, so that
+// a short outage of the secret manager does not fail the admin calls. The
+// failure is reported by RefreshError.
+type SecretKeyProvider struct {
+	fetch   SecretFunc
+	refresh time.Duration
+
+	mu         sync.Mutex
+	key        []byte
+	fetchedAt  time.Time
+	refreshErr error
+	now        func() time.Time
+}
+
+// NewSecretKeyProvider creates a provider fetching the admin key with fetch
+// and fetching it again once the refresh interval has passed.
+func NewSecretKeyProvider(fetch SecretFunc,
+	refresh time.Duration) (*SecretKeyProvider, error) {
+	p := &SecretKeyProvider{fetch: fetch, refresh: refresh, now: time.Now}
+	if err := useAdminKey(p, func([]byte) {}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AdminKey returns the decoded admin key, fetching it again if the refresh
+// interval has passed. An error is only returned if no key is cached.
+func (p *SecretKeyProvider) AdminKey() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.key != nil && p.now().Sub(p.fetchedAt) < p.refresh {
+		return copyKey(p.key), nil
+	}
+
+	key, err := p.fetchKey()
+	if err != nil {
+		if p.key == nil {
+			return nil, err
+		}
+		p.refreshErr, p.fetchedAt = err, p.now()
+		return copyKey(p.key), nil
+	}
+	wipe(p.key)
+	p.key, p.fetchedAt, p.refreshErr = key, p.now(), nil
+	return copyKey(key), nil
+}
+
+func (*SecretKeyProvider) copiesAdminKey() {}
+
+func (p *SecretKeyProvider) fetchKey() ([]byte, error) {
+	secret, err := p.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return decodeAdminKey(strings.TrimSpace(secret))
+}
+
+// RefreshError returns the error of the last attempt to fetch the admin key
+// again, or nil if it succeeded.
+func (p *SecretKeyProvider) RefreshError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.refreshErr
+}
+
+// Wipe zeroes the admin key kept in memory. It is fetched again by the next
+// call to AdminKey.
+func (p *SecretKeyProvider) Wipe() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wipe(p.key)
+	p.key = nil
+}
@@ -0,0 +1,267 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewClientRejectsInvalidAdminKey(t *testing.T) {
+	_, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, "0g")
+	if err != ErrInvalidAdminKey {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidAdminKey)
+	}
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	const name = "ZEROKIT_TEST_ADMIN_KEY"
+	os.Setenv(name, AdminKey+"\n")
+	defer os.Unsetenv(name)
+
+	p, err := NewEnvKeyProvider(name)
+	if err != nil {
+		t.Fatalf("cannot create env key provider: %v", err)
+	}
+	key, err := p.AdminKey()
+	if err != nil || hex.EncodeToString(key) != AdminKey {
+		t.Errorf("AdminKey() = %x, %v, want = %s", key, err, AdminKey)
+	}
+
+	os.Setenv(name, "0g")
+	if _, err := p.AdminKey(); err != ErrInvalidAdminKey {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidAdminKey)
+	}
+
+	os.Unsetenv(name)
+	if _, err := NewEnvKeyProvider(name); err == nil {
+		t.Error("expected error for unset environment variable")
+	}
+}
+
+func TestFileKeyProviderReloadsRotatedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zerokit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "admin-key")
+	if err := ioutil.WriteFile(path, []byte(AdminKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("cannot create file key provider: %v", err)
+	}
+	key, err := p.AdminKey()
+	if err != nil || hex.EncodeToString(key) != AdminKey {
+		t.Errorf("AdminKey() = %x, %v, want = %s", key, err, AdminKey)
+	}
+
+	rotated := "a1b2c3d4e5f6"
+	if err := ioutil.WriteFile(path, []byte(rotated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = p.AdminKey()
+	if err != nil || hex.EncodeToString(key) != rotated {
+		t.Errorf("AdminKey() = %x, %v, want = %s", key, err, rotated)
+	}
+}
+
+func TestFileKeyProviderInvalidKey(t *testing.T) {
+	f, err := ioutil.TempFile("", "zerokit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("not-hex")
+	f.Close()
+
+	if _, err := NewFileKeyProvider(f.Name()); err != ErrInvalidAdminKey {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidAdminKey)
+	}
+}
+
+func TestSecretKeyProviderRefresh(t *testing.T) {
+	keys := []string{AdminKey, "a1b2c3d4e5f6"}
+	fetches := 0
+	fetch := func() (string, error) {
+		key := keys[fetches]
+		fetches++
+		return key, nil
+	}
+
+	p, err := NewSecretKeyProvider(fetch, time.Minute)
+	if err != nil {
+		t.Fatalf("cannot create secret key provider: %v", err)
+	}
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	key, _ := p.AdminKey()
+	if hex.EncodeToString(key) != AdminKey || fetches != 1 {
+		t.Errorf("AdminKey() = %x after %d fetches, want = %s after 1",
+			key, fetches, AdminKey)
+	}
+
+	now = now.Add(2 * time.Minute)
+	key, _ = p.AdminKey()
+	if hex.EncodeToString(key) != keys[1] || fetches != 2 {
+		t.Errorf("AdminKey() = %x after %d fetches, want = %s after 2",
+			key, fetches, keys[1])
+	}
+}
+
+func TestSecretKeyProviderFetchError(t *testing.T) {
+	failure := errors.New("secret manager unavailable")
+	_, err := NewSecretKeyProvider(func() (string, error) {
+		return "", failure
+	}, time.Minute)
+	if err != failure {
+		t.Errorf("err = %v, want = %v", err, failure)
+	}
+}
+
+func TestCloseWipesAdminKeys(t *testing.T) {
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithSecondaryAdminKey(RotatedAdminKey))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	primary, secondary := c.signingKeys()
+	primaryKey := primary.(*staticKey).key
+	secondaryKey := secondary.(*staticKey).key
+
+	c.Close()
+
+	for _, key := range [][]byte{primaryKey, secondaryKey} {
+		if !bytes.Equal(key, make([]byte, len(key))) {
+			t.Errorf("admin key is not wiped: %x", key)
+		}
+	}
+	if _, err := primary.AdminKey(); err != ErrAdminKeyWiped {
+		t.Errorf("err = %v, want = %v", err, ErrAdminKeyWiped)
+	}
+	if err := c.ApproveTresorCreation("xyz"); err != ErrAdminKeyWiped {
+		t.Errorf("err = %v, want = %v", err, ErrAdminKeyWiped)
+	}
+}
+
+func TestSecretKeyProviderKeepsKeyOnRefreshError(t *testing.T) {
+	failure := errors.New("secret manager unavailable")
+	var fetchErr error
+	fetches := 0
+	p, err := NewSecretKeyProvider(func() (string, error) {
+		fetches++
+		return AdminKey, fetchErr
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("cannot create secret key provider: %v", err)
+	}
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	fetchErr = failure
+	now = now.Add(2 * time.Minute)
+	key, err := p.AdminKey()
+	if err != nil || hex.EncodeToString(key) != AdminKey {
+		t.Errorf("AdminKey() = %x, %v, want = %s, nil", key, err, AdminKey)
+	}
+	if p.RefreshError() != failure {
+		t.Errorf("RefreshError() = %v, want = %v", p.RefreshError(), failure)
+	}
+
+	// the next attempt is made once the refresh interval has passed again
+	p.AdminKey()
+	if fetches != 2 {
+		t.Errorf("fetches = %d, want = %d", fetches, 2)
+	}
+	fetchErr = nil
+	now = now.Add(2 * time.Minute)
+	p.AdminKey()
+	if fetches != 3 || p.RefreshError() != nil {
+		t.Errorf("fetches = %d, RefreshError() = %v, want = 3, nil",
+			fetches, p.RefreshError())
+	}
+}
+
+func TestAdminKeyIsCopied(t *testing.T) {
+	p, err := newStaticKey(AdminKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := p.AdminKey()
+	p.Wipe()
+	if hex.EncodeToString(key) != AdminKey {
+		t.Errorf("key in use = %x after Wipe, want = %s", key, AdminKey)
+	}
+}
+
+func TestCloseWhileSigning(t *testing.T) {
+	started := make(chan struct{})
+	var once sync.Once
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		once.Do(func() { close(started) })
+		return response(http.StatusOK, ""), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := c.ApproveTresorCreation("xyz")
+				if err != nil && err != ErrAdminKeyWiped {
+					t.Errorf("err = %v, want = nil or %v", err, ErrAdminKeyWiped)
+				}
+			}
+		}()
+	}
+	<-started
+	c.Close()
+	wg.Wait()
+}
@@ -0,0 +1,128 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// TresorMembers are the members of a tresor as listed by the admin API.
+type TresorMembers struct {
+	TresorId string
+	// Members are the ids of the users the tresor is shared with.
+	Members []string
+	// Extra holds the undecoded fields of the response other than Members,
+	// which are returned by newer versions of the admin API.
+	Extra map[string]json.RawMessage
+}
+
+// Contains reports whether the user is a member of the tresor.
+func (m *TresorMembers) Contains(userId string) bool {
+	return contains(m.Members, userId)
+}
+
+// UnmarshalJSON decodes the list-members response, keeping the fields other
+// than Members in Extra. They are kept even if strict decoding is enabled.
+func (m *TresorMembers) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if members, ok := fields["Members"]; ok {
+		if err := json.Unmarshal(members, &m.Members); err != nil {
+			return err
+		}
+		delete(fields, "Members")
+	}
+	if len(fields) > 0 {
+		m.Extra = fields
+	}
+	return nil
+}
+
+func (m *TresorMembers) missingFields() []string {
+	if m.Members == nil {
+		return []string{"Members"}
+	}
+	return nil
+}
+
+// ListTresorMembersDetailed is like ListTresorMembers, but returns the whole
+// response of the admin API. It bypasses the members cache.
+func (c *ZeroKitAdminApiClient) ListTresorMembersDetailed(
+	tresorId string) (*TresorMembers, error) {
+	return c.ListTresorMembersDetailedContext(context.Background(), tresorId)
+}
+
+// ListTresorMembersDetailedContext is like ListTresorMembersDetailed, but
+// carries the given context with the request.
+func (c *ZeroKitAdminApiClient) ListTresorMembersDetailedContext(
+	ctx context.Context, tresorId string) (*TresorMembers, error) {
+	q := url.Values{}
+	q.Add("tresorid", tresorId)
+
+	members, err := call[struct{}, TresorMembers](
+		ctx, c, listTresorMembers, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	members.TresorId = tresorId
+	return members, nil
+}
+
+// IsMember reports whether the user is a member of the tresor. It uses the
+// members cache, if enabled, which makes it cheap enough to authorize
+// requests with.
+func (c *ZeroKitAdminApiClient) IsMember(tresorId, userId string) (bool, error) {
+	return c.IsMemberContext(context.Background(), tresorId, userId)
+}
+
+// IsMemberContext is like IsMember, but carries the given context with the
+// request.
+func (c *ZeroKitAdminApiClient) IsMemberContext(ctx context.Context, tresorId,
+	userId string) (bool, error) {
+	members, err := c.ListTresorMembersContext(ctx, tresorId)
+	if err != nil {
+		return false, err
+	}
+	return contains(members, userId), nil
+}
+
+func contains(members []string, userId string) bool {
+	for _, member := range members {
+		if member == userId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListTresorMembersDetailed(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != ListTresorMembersPath || req.URL.Query().Get("tresorid") != "xyz" {
+			t.Errorf("unexpected request: %s", req.URL)
+		}
+		return response(http.StatusOK,
+			`{"Members":["zk1","zk2"],"Owner":"zk1","Version":3}`), nil
+	}, WithStrictDecoding())
+
+	members, err := c.ListTresorMembersDetailed("xyz")
+	if err != nil {
+		t.Fatalf("list tresor members must not fail, was = %v", err)
+	}
+	if members.TresorId != "xyz" {
+		t.Errorf("TresorId = %s, want = %s", members.TresorId, "xyz")
+	}
+	if !reflect.DeepEqual(members.Members, []string{"zk1", "zk2"}) {
+		t.Errorf("Members = %v, want = %v", members.Members, []string{"zk1", "zk2"})
+	}
+	want := map[string]json.RawMessage{
+		"Owner":   json.RawMessage(`"zk1"`),
+		"Version": json.RawMessage(`3`),
+	}
+	if !reflect.DeepEqual(members.Extra, want) {
+		t.Errorf("Extra = %s, want = %s", members.Extra, want)
+	}
+	if !members.Contains("zk2") || members.Contains("zk3") {
+		t.Errorf("unexpected membership of %v", members.Members)
+	}
+}
+
+func TestListTresorMembersDetailedMissingMembers(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Owner":"zk1"}`), nil
+	})
+
+	_, err := c.ListTresorMembersDetailed("xyz")
+	if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("err = %v, want *DecodeError", err)
+	}
+}
+
+func TestIsMember(t *testing.T) {
+	requests := 0
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		requests++
+		return response(http.StatusOK, `{"Members":["zk1","zk2"]}`), nil
+	}, WithMembersCache(time.Minute, 10))
+
+	for userId, want := range map[string]bool{"zk1": true, "zk2": true, "zk3": false} {
+		isMember, err := c.IsMember("xyz", userId)
+		if err != nil {
+			t.Fatalf("is member must not fail, was = %v", err)
+		}
+		if isMember != want {
+			t.Errorf("IsMember(%s) = %v, want = %v", userId, isMember, want)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the members to be listed once, got %d requests", requests)
+	}
+}
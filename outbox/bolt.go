@@ -0,0 +1,275 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("zerokit_outbox")
+
+// boltDueBucket indexes the pending operations by the time of their next
+// attempt, so that the due ones are found without reading all operations.
+var boltDueBucket = []byte("zerokit_outbox_due")
+
+// BoltStore is a Store kept in a local bbolt file. The file is locked by
+// the process which opened it.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the store in the file at path, creating it if it does
+// not exist.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(boltDueBucket) != nil {
+			return nil
+		}
+		// the file has been created before the index was introduced
+		due, err := tx.CreateBucket(boltDueBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var op Operation
+			if err := json.Unmarshal(v, &op); err != nil {
+				return err
+			}
+			if op.State != StatePending {
+				return nil
+			}
+			return due.Put(dueKey(op.NextAttempt, op.Key), []byte{})
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the file.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Add stores the operation, unless an operation with its key exists.
+func (s *BoltStore) Add(_ context.Context, op Operation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltBucket).Get([]byte(op.Key)) != nil {
+			return nil
+		}
+		return putOperation(tx, nil, &op)
+	})
+}
+
+// Get returns the operation with the key, or ErrNotFound.
+func (s *BoltStore) Get(_ context.Context, key string) (*Operation, error) {
+	var op *Operation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		op, err = getOperation(tx.Bucket(boltBucket), key)
+		return err
+	})
+	return op, err
+}
+
+// Claim returns up to limit pending operations due at now, postponing them
+// by lease. If no operation is due, the file is not written.
+func (s *BoltStore) Claim(_ context.Context, now time.Time, lease time.Duration,
+	limit int) ([]Operation, error) {
+	end := dueKey(now, "")
+	anyDue := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(boltDueBucket).Cursor().First()
+		anyDue = k != nil && bytes.Compare(k[:dueKeyLen], end) <= 0
+		return nil
+	})
+	if err != nil || !anyDue {
+		return nil, err
+	}
+
+	var due []Operation
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		c := tx.Bucket(boltDueBucket).Cursor()
+		for k, _ := c.First(); k != nil && len(due) < limit; k, _ = c.Next() {
+			if bytes.Compare(k[:dueKeyLen], end) > 0 {
+				break
+			}
+			op, err := getOperation(b, string(k[dueKeyLen:]))
+			if err != nil {
+				return err
+			}
+			due = append(due, *op)
+		}
+		for i := range due {
+			prev := due[i]
+			due[i].Attempts++
+			due[i].NextAttempt = now.Add(lease)
+			due[i].Updated = now
+			if err := putOperation(tx, &prev, &due[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// Complete marks the operation as done.
+func (s *BoltStore) Complete(_ context.Context, key string, now time.Time) error {
+	return s.update(key, func(op *Operation) {
+		op.State, op.LastError, op.Updated = StateDone, "", now
+	})
+}
+
+// Retry schedules the next attempt of the operation.
+func (s *BoltStore) Retry(_ context.Context, key string, next time.Time,
+	lastErr string) error {
+	return s.update(key, func(op *Operation) {
+		op.NextAttempt, op.LastError = next, lastErr
+	})
+}
+
+// Bury marks the operation as dead.
+func (s *BoltStore) Bury(_ context.Context, key string, now time.Time,
+	lastErr string) error {
+	return s.update(key, func(op *Operation) {
+		op.State, op.LastError, op.Updated = StateDead, lastErr, now
+	})
+}
+
+// Prune deletes the done and dead operations last updated before the given
+// time.
+func (s *BoltStore) Prune(_ context.Context, before time.Time) (int, error) {
+	n := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var op Operation
+			if err := json.Unmarshal(v, &op); err != nil {
+				return err
+			}
+			if op.State != StatePending && op.Updated.Before(before) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) update(key string, fn func(*Operation)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		op, err := getOperation(tx.Bucket(boltBucket), key)
+		if err != nil {
+			return err
+		}
+		prev := *op
+		fn(op)
+		return putOperation(tx, &prev, op)
+	})
+}
+
+func getOperation(b *bolt.Bucket, key string) (*Operation, error) {
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	var op Operation
+	if err := json.Unmarshal(v, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// putOperation stores the operation and updates the index of the pending
+// operations. prev is the stored version of the operation, if any.
+func putOperation(tx *bolt.Tx, prev, op *Operation) error {
+	v, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltBucket).Put([]byte(op.Key), v); err != nil {
+		return err
+	}
+	due := tx.Bucket(boltDueBucket)
+	if prev != nil && prev.State == StatePending {
+		if err := due.Delete(dueKey(prev.NextAttempt, prev.Key)); err != nil {
+			return err
+		}
+	}
+	if op.State != StatePending {
+		return nil
+	}
+	return due.Put(dueKey(op.NextAttempt, op.Key), []byte{})
+}
+
+// dueKeyLen is the length of the time prefix of the keys of boltDueBucket.
+const dueKeyLen = 12
+
+// dueKey returns the key of the operation in boltDueBucket, which sorts by
+// the time of the next attempt.
+func dueKey(next time.Time, key string) []byte {
+	k := make([]byte, dueKeyLen, dueKeyLen+len(key))
+	binary.BigEndian.PutUint64(k, uint64(next.Unix())^1<<63)
+	binary.BigEndian.PutUint32(k[8:], uint32(next.Nanosecond()))
+	return append(k, key...)
+}
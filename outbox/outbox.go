@@ -0,0 +1,445 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package outbox persists pending admin operations before they are executed,
+// so that they survive a crash of the service. The operations are executed
+// at least once by a worker pool, which retries them with exponential
+// backoff. Every operation has an idempotency key; enqueuing an operation
+// with the key of a stored one has no effect.
+//
+// An operation which is executed again after a crash or a lost response is
+// refused by the tenant. The outbox completes it only if the client
+// recognizes the refusal as zerokit.ErrAlreadyDone, so the client has to be
+// created with the error codes of the tenant in zerokit.WithAlreadyDoneCodes;
+// otherwise such operations are buried as dead.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+// Kind is the admin operation to execute.
+type Kind string
+
+// The admin operations which can be enqueued.
+const (
+	KindApproveTresorCreation    Kind = "ApproveTresorCreation"
+	KindApproveShare             Kind = "ApproveShare"
+	KindApproveKick              Kind = "ApproveKick"
+	KindValidateUserRegistration Kind = "ValidateUserRegistration"
+)
+
+// State is the processing state of an operation.
+type State string
+
+// The processing states of an operation.
+const (
+	// StatePending operations are executed once they are due.
+	StatePending State = "pending"
+	// StateDone operations have been executed successfully.
+	StateDone State = "done"
+	// StateDead operations have failed permanently, or too often.
+	StateDead State = "dead"
+)
+
+// ErrNotFound is returned for operations missing in the store.
+var ErrNotFound = errors.New("operation not found")
+
+// Operation is an admin operation kept in the outbox.
+type Operation struct {
+	// Key is the idempotency key of the operation.
+	Key  string
+	Kind Kind
+	// TresorId is the tresor of KindApproveTresorCreation.
+	TresorId string `json:",omitempty"`
+	// OperationId is the operation of KindApproveShare and KindApproveKick.
+	OperationId string `json:",omitempty"`
+	// Registration is the request of KindValidateUserRegistration. Its
+	// verifiers are stored in plain text until the operation is pruned.
+	Registration *zerokit.ValidateUserRegistrationRequest `json:",omitempty"`
+
+	State State
+	// Attempts is the number of times the operation has been claimed.
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string `json:",omitempty"`
+	Created     time.Time
+	Updated     time.Time
+}
+
+// ApproveTresorCreation returns the operation approving the tresor.
+func ApproveTresorCreation(key, tresorId string) Operation {
+	return Operation{Key: key, Kind: KindApproveTresorCreation, TresorId: tresorId}
+}
+
+// ApproveShare returns the operation approving the share operation.
+func ApproveShare(key, operationId string) Operation {
+	return Operation{Key: key, Kind: KindApproveShare, OperationId: operationId}
+}
+
+// ApproveKick returns the operation approving the kick operation.
+func ApproveKick(key, operationId string) Operation {
+	return Operation{Key: key, Kind: KindApproveKick, OperationId: operationId}
+}
+
+// ValidateUserRegistration returns the operation completing the
+// registration.
+func ValidateUserRegistration(key string,
+	req zerokit.ValidateUserRegistrationRequest) Operation {
+	return Operation{Key: key, Kind: KindValidateUserRegistration, Registration: &req}
+}
+
+func (op *Operation) validate() error {
+	if op.Key == "" {
+		return errors.New("operation without idempotency key")
+	}
+	switch op.Kind {
+	case KindApproveTresorCreation:
+		if op.TresorId == "" {
+			return errors.New("operation without tresor id")
+		}
+	case KindApproveShare, KindApproveKick:
+		if op.OperationId == "" {
+			return errors.New("operation without operation id")
+		}
+	case KindValidateUserRegistration:
+		if op.Registration == nil {
+			return errors.New("operation without registration")
+		}
+		return op.Registration.Validate()
+	default:
+		return errors.New(fmt.Sprintf("unknown operation kind: %s", op.Kind))
+	}
+	return nil
+}
+
+// Store persists the operations. It must be safe for concurrent use, also
+// by several processes sharing the store.
+type Store interface {
+	// Add stores the operation, unless an operation with its key exists.
+	Add(ctx context.Context, op Operation) error
+	// Get returns the operation with the key, or ErrNotFound.
+	Get(ctx context.Context, key string) (*Operation, error)
+	// Claim returns up to limit pending operations due at now, the earliest
+	// first. Their attempts are incremented and their next attempt is
+	// postponed by lease, so that they are claimed again if they are
+	// neither completed nor retried in time.
+	Claim(ctx context.Context, now time.Time, lease time.Duration,
+		limit int) ([]Operation, error)
+	// Complete marks the operation as done.
+	Complete(ctx context.Context, key string, now time.Time) error
+	// Retry schedules the next attempt of the operation.
+	Retry(ctx context.Context, key string, next time.Time, lastErr string) error
+	// Bury marks the operation as dead.
+	Bury(ctx context.Context, key string, now time.Time, lastErr string) error
+	// Prune deletes the done and dead operations last updated before the
+	// given time. Their keys are forgotten, so they can be enqueued again.
+	Prune(ctx context.Context, before time.Time) (int, error)
+}
+
+// Outbox executes the operations of a Store with a ZeroKit admin client.
+type Outbox struct {
+	client zerokit.AdminClient
+	store  Store
+
+	workers      int
+	maxAttempts  int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	lease        time.Duration
+	pollInterval time.Duration
+	onDead       func(Operation)
+	onError      func(error)
+	now          func() time.Time
+}
+
+// Option configures optional behaviour of the Outbox.
+type Option func(*Outbox)
+
+// WithWorkers sets the number of operations executed at the same time.
+// Defaults to 4.
+func WithWorkers(n int) Option {
+	return func(o *Outbox) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithMaxAttempts sets the number of attempts after which a failing
+// operation is dead. Defaults to 10.
+func WithMaxAttempts(n int) Option {
+	return func(o *Outbox) {
+		o.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before the first retry, which doubles with
+// every further attempt up to max. Defaults to 1s and 5m.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *Outbox) {
+		o.minBackoff, o.maxBackoff = min, max
+	}
+}
+
+// WithLease sets the time an operation is claimed for. It must exceed the
+// time it takes to execute the operation; an operation whose lease expires
+// is executed again. Defaults to 1m.
+func WithLease(d time.Duration) Option {
+	return func(o *Outbox) {
+		o.lease = d
+	}
+}
+
+// WithPollInterval sets how often Run looks for due operations while the
+// outbox is idle. Defaults to 1s.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *Outbox) {
+		o.pollInterval = d
+	}
+}
+
+// WithDeadHandler sets the function called when an operation is dead.
+func WithDeadHandler(fn func(Operation)) Option {
+	return func(o *Outbox) {
+		o.onDead = fn
+	}
+}
+
+// WithErrorHandler sets the function called when Run fails to access the
+// store. Run continues after such errors. The function may be called by
+// several workers at the same time.
+func WithErrorHandler(fn func(error)) Option {
+	return func(o *Outbox) {
+		o.onError = fn
+	}
+}
+
+// New creates an outbox executing the operations in store with client,
+// which should be created with zerokit.WithAlreadyDoneCodes, see above.
+func New(client zerokit.AdminClient, store Store, opts ...Option) *Outbox {
+	o := &Outbox{
+		client:       client,
+		store:        store,
+		workers:      4,
+		maxAttempts:  10,
+		minBackoff:   time.Second,
+		maxBackoff:   5 * time.Minute,
+		lease:        time.Minute,
+		pollInterval: time.Second,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Enqueue stores the operation to be executed as soon as possible. Once it
+// returns, the operation is executed even if the service crashes. An
+// operation with the key of a stored one is ignored.
+func (o *Outbox) Enqueue(ctx context.Context, op Operation) error {
+	if err := op.validate(); err != nil {
+		return err
+	}
+	now := o.now().UTC()
+	op.State = StatePending
+	op.Attempts = 0
+	op.NextAttempt = now
+	op.LastError = ""
+	op.Created, op.Updated = now, now
+	return o.store.Add(ctx, op)
+}
+
+// Run executes the due operations until the context is done, and returns
+// its error. Every worker claims the next due operation as soon as it has
+// finished the previous one, so that a slow call does not hold up the others.
+func (o *Outbox) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// work executes the due operations one after the other, polling for new ones
+// when none is due.
+func (o *Outbox) work(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		executed, err := o.next(ctx)
+		if err != nil && ctx.Err() == nil && o.onError != nil {
+			o.onError(err)
+		}
+		if executed && err == nil {
+			timer.Reset(0)
+		} else {
+			timer.Reset(o.pollInterval)
+		}
+	}
+}
+
+// Process executes the due operations with the workers until none is due
+// any more, and returns their number. Like in Run, every worker claims the
+// next operation as soon as it has finished the previous one.
+func (o *Outbox) Process(ctx context.Context) (int, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		n        int
+		firstErr error
+	)
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				executed, err := o.next(ctx)
+				mu.Lock()
+				if executed {
+					n++
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				if !executed || err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return n, firstErr
+}
+
+// next claims the next due operation and executes it. It reports whether an
+// operation was due.
+func (o *Outbox) next(ctx context.Context) (bool, error) {
+	ops, err := o.store.Claim(ctx, o.now().UTC(), o.lease, 1)
+	if err != nil || len(ops) == 0 {
+		return false, err
+	}
+	return true, o.execute(ctx, ops[0])
+}
+
+// execute executes the operation and records the outcome. The returned
+// error is the one of the store.
+func (o *Outbox) execute(ctx context.Context, op Operation) error {
+	err := o.call(ctx, op)
+	if ctx.Err() != nil {
+		// the operation is claimed again once its lease expires
+		return nil
+	}
+	now := o.now().UTC()
+	if err == nil || errors.Is(err, zerokit.ErrAlreadyDone) {
+		// a repeated execution finds the operation done by the previous one
+		return o.store.Complete(ctx, op.Key, now)
+	}
+
+	if !retryable(err) || op.Attempts >= o.maxAttempts {
+		if storeErr := o.store.Bury(ctx, op.Key, now, err.Error()); storeErr != nil {
+			return storeErr
+		}
+		if o.onDead != nil {
+			op.State, op.LastError, op.Updated = StateDead, err.Error(), now
+			o.onDead(op)
+		}
+		return nil
+	}
+	return o.store.Retry(ctx, op.Key, now.Add(o.backoff(op.Attempts)), err.Error())
+}
+
+func (o *Outbox) call(ctx context.Context, op Operation) error {
+	switch op.Kind {
+	case KindApproveTresorCreation:
+		return o.client.ApproveTresorCreationContext(ctx, op.TresorId)
+	case KindApproveShare:
+		return o.client.ApproveShareContext(ctx, op.OperationId)
+	case KindApproveKick:
+		return o.client.ApproveKickContext(ctx, op.OperationId)
+	case KindValidateUserRegistration:
+		r := op.Registration
+		return o.client.ValidateUserRegistrationContext(ctx, string(r.UserId),
+			string(r.RegSessionId), r.RegSessionVerifier, r.RegValidationVerifier)
+	}
+	return errors.New(fmt.Sprintf("unknown operation kind: %s", op.Kind))
+}
+
+// backoff returns the delay before the next attempt.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.minBackoff
+	for i := 1; i < attempts && d < o.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.maxBackoff {
+		d = o.maxBackoff
+	}
+	return d
+}
+
+// retryable reports whether the operation may succeed when executed again.
+// Client errors of the admin API are permanent, except for timeouts, rate
+// limiting and rejected credentials, which are fixed by rotating the admin
+// key or granting the missing permission.
+func retryable(err error) bool {
+	var apiErr *zerokit.APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusRequestTimeout,
+		apiErr.StatusCode == http.StatusTooManyRequests,
+		apiErr.StatusCode == http.StatusUnauthorized,
+		apiErr.StatusCode == http.StatusForbidden:
+		return true
+	case apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		return false
+	}
+	return true
+}
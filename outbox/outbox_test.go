@@ -0,0 +1,380 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package outbox
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+	"github.com/gesundheitscloud/go-zerokit-api-client/zerokittest"
+)
+
+// clock is a manually advanced time.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestOutbox(t *testing.T, client zerokit.AdminClient,
+	opts ...Option) (*Outbox, Store, *clock) {
+	s := newBoltStore(t)
+	o := New(client, s, opts...)
+	c := &clock{now: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)}
+	o.now = c.Now
+	return o, s, c
+}
+
+func process(t *testing.T, o *Outbox) int {
+	n, err := o.Process(context.Background())
+	if err != nil {
+		t.Fatalf("process must not fail, was = %v", err)
+	}
+	return n
+}
+
+func state(t *testing.T, s Store, key string) *Operation {
+	op, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func TestOutboxExecutesOperations(t *testing.T) {
+	fake := &zerokittest.Fake{}
+	o, s, _ := newTestOutbox(t, fake, WithWorkers(8))
+	ctx := context.Background()
+
+	for _, op := range []Operation{
+		ApproveTresorCreation("a", "tresor1"),
+		ApproveShare("b", "op1"),
+		ApproveKick("c", "op2"),
+		ValidateUserRegistration("d", zerokit.ValidateUserRegistrationRequest{
+			UserId:                "0000slpj4r86xbqlg9wmjhug",
+			RegSessionId:          "session",
+			RegSessionVerifier:    "verifier",
+			RegValidationVerifier: "validation",
+		}),
+		ApproveTresorCreation("a", "tresor1"),
+	} {
+		if err := o.Enqueue(ctx, op); err != nil {
+			t.Fatalf("enqueue must not fail, was = %v", err)
+		}
+	}
+
+	if n := process(t, o); n != 4 {
+		t.Errorf("expected 4 operations to be processed, got %d", n)
+	}
+	for method, args := range map[string][]string{
+		"ApproveTresorCreation": {"tresor1"},
+		"ApproveShare":          {"op1"},
+		"ApproveKick":           {"op2"},
+		"ValidateUserRegistration": {"0000slpj4r86xbqlg9wmjhug", "session",
+			"verifier", "validation"},
+	} {
+		calls := fake.CallsTo(method)
+		if len(calls) != 1 || !reflect.DeepEqual(calls[0].Args, args) {
+			t.Errorf("calls to %s = %v, want one with %v", method, calls, args)
+		}
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if op := state(t, s, key); op.State != StateDone {
+			t.Errorf("expected %s to be done, was = %+v", key, op)
+		}
+	}
+
+	if err := o.Enqueue(ctx, ApproveTresorCreation("a", "tresor1")); err != nil {
+		t.Fatal(err)
+	}
+	if n := process(t, o); n != 0 {
+		t.Errorf("expected operation with a known key to be ignored, got %d", n)
+	}
+}
+
+func TestOutboxRejectsInvalidOperations(t *testing.T) {
+	o, _, _ := newTestOutbox(t, &zerokittest.Fake{})
+	for _, op := range []Operation{
+		ApproveTresorCreation("", "tresor1"),
+		ApproveShare("a", ""),
+		{Key: "a", Kind: "Unknown"},
+		ValidateUserRegistration("a", zerokit.ValidateUserRegistrationRequest{UserId: "zk"}),
+	} {
+		if err := o.Enqueue(context.Background(), op); err == nil {
+			t.Errorf("operation %+v must be rejected", op)
+		}
+	}
+}
+
+func TestOutboxRetriesWithBackoff(t *testing.T) {
+	failures := 2
+	fake := &zerokittest.Fake{
+		ApproveShareFunc: func(ctx context.Context, operationId string) error {
+			if failures > 0 {
+				failures--
+				return &zerokit.APIError{StatusCode: http.StatusServiceUnavailable}
+			}
+			return nil
+		},
+	}
+	o, s, c := newTestOutbox(t, fake, WithBackoff(time.Second, time.Minute))
+	if err := o.Enqueue(context.Background(), ApproveShare("a", "op1")); err != nil {
+		t.Fatal(err)
+	}
+
+	process(t, o)
+	if op := state(t, s, "a"); op.State != StatePending || op.LastError == "" ||
+		!op.NextAttempt.Equal(c.Now().Add(time.Second)) {
+		t.Fatalf("expected retry in 1s, was = %+v", op)
+	}
+	if n := process(t, o); n != 0 {
+		t.Errorf("expected no operation to be due, got %d", n)
+	}
+
+	c.Advance(time.Second)
+	process(t, o)
+	if op := state(t, s, "a"); !op.NextAttempt.Equal(c.Now().Add(2 * time.Second)) {
+		t.Fatalf("expected retry in 2s, was = %+v", op)
+	}
+
+	c.Advance(2 * time.Second)
+	process(t, o)
+	if op := state(t, s, "a"); op.State != StateDone || op.Attempts != 3 {
+		t.Errorf("expected done after 3 attempts, was = %+v", op)
+	}
+}
+
+func TestOutboxDeadOperations(t *testing.T) {
+	var dead []Operation
+	fake := &zerokittest.Fake{
+		ApproveShareFunc: func(ctx context.Context, operationId string) error {
+			return &zerokit.APIError{StatusCode: http.StatusBadRequest}
+		},
+		ApproveKickFunc: func(ctx context.Context, operationId string) error {
+			return &zerokit.APIError{StatusCode: http.StatusTooManyRequests}
+		},
+	}
+	o, s, c := newTestOutbox(t, fake, WithMaxAttempts(2),
+		WithBackoff(time.Second, time.Second),
+		WithDeadHandler(func(op Operation) { dead = append(dead, op) }))
+	ctx := context.Background()
+	o.Enqueue(ctx, ApproveShare("permanent", "op1"))
+	o.Enqueue(ctx, ApproveKick("transient", "op2"))
+
+	process(t, o)
+	if op := state(t, s, "permanent"); op.State != StateDead {
+		t.Errorf("expected client error to be permanent, was = %+v", op)
+	}
+	if op := state(t, s, "transient"); op.State != StatePending {
+		t.Errorf("expected rate limiting to be retried, was = %+v", op)
+	}
+
+	c.Advance(time.Second)
+	process(t, o)
+	if op := state(t, s, "transient"); op.State != StateDead || op.Attempts != 2 {
+		t.Errorf("expected dead after 2 attempts, was = %+v", op)
+	}
+	if len(dead) != 2 || dead[0].Key != "permanent" || dead[1].Key != "transient" ||
+		dead[1].State != StateDead {
+		t.Errorf("unexpected dead operations: %+v", dead)
+	}
+}
+
+func TestOutboxRetriesRejectedCredentials(t *testing.T) {
+	var mu sync.Mutex
+	status := map[string]int{
+		"op1": http.StatusUnauthorized,
+		"op2": http.StatusForbidden,
+	}
+	fake := &zerokittest.Fake{
+		ApproveShareFunc: func(ctx context.Context, operationId string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if code := status[operationId]; code != 0 {
+				delete(status, operationId)
+				return &zerokit.APIError{StatusCode: code}
+			}
+			return nil
+		},
+	}
+	o, s, c := newTestOutbox(t, fake, WithBackoff(time.Second, time.Second))
+	ctx := context.Background()
+	o.Enqueue(ctx, ApproveShare("unauthorized", "op1"))
+	o.Enqueue(ctx, ApproveShare("forbidden", "op2"))
+
+	process(t, o)
+	for _, key := range []string{"unauthorized", "forbidden"} {
+		if op := state(t, s, key); op.State != StatePending {
+			t.Errorf("expected %s to be retried, was = %+v", key, op)
+		}
+	}
+
+	// the admin key has been rotated meanwhile
+	c.Advance(time.Second)
+	process(t, o)
+	for _, key := range []string{"unauthorized", "forbidden"} {
+		if op := state(t, s, key); op.State != StateDone {
+			t.Errorf("expected %s to be done, was = %+v", key, op)
+		}
+	}
+}
+
+func TestOutboxExecutesAtLeastOnce(t *testing.T) {
+	fake := &zerokittest.Fake{}
+	o, s, c := newTestOutbox(t, fake, WithLease(time.Minute))
+	ctx := context.Background()
+	o.Enqueue(ctx, ApproveTresorCreation("a", "tresor1"))
+
+	// a worker claims the operation and crashes
+	if claimed, _ := s.Claim(ctx, c.Now(), time.Minute, 1); len(claimed) != 1 {
+		t.Fatal("expected the operation to be claimed")
+	}
+	if n := process(t, o); n != 0 {
+		t.Errorf("expected claimed operation not to be due, got %d", n)
+	}
+
+	c.Advance(time.Minute)
+	if n := process(t, o); n != 1 {
+		t.Errorf("expected operation to be due once its lease expired, got %d", n)
+	}
+	if op := state(t, s, "a"); op.State != StateDone || op.Attempts != 2 {
+		t.Errorf("expected done after 2 attempts, was = %+v", op)
+	}
+}
+
+func TestOutboxRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fake := &zerokittest.Fake{
+		ApproveTresorCreationFunc: func(context.Context, string) error {
+			cancel()
+			return nil
+		},
+	}
+	o := New(fake, newBoltStore(t), WithPollInterval(time.Millisecond))
+	o.Enqueue(context.Background(), ApproveTresorCreation("a", "tresor1"))
+
+	done := make(chan error)
+	go func() { done <- o.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("err = %v, want = %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run to stop")
+	}
+	if calls := fake.CallsTo("ApproveTresorCreation"); len(calls) != 1 {
+		t.Errorf("expected the operation to be executed, got %v", calls)
+	}
+}
+
+func TestOutboxSlowOperationDoesNotHoldUpWorkers(t *testing.T) {
+	release := make(chan struct{})
+	fast := make(chan string, 3)
+	fake := &zerokittest.Fake{
+		ApproveTresorCreationFunc: func(ctx context.Context, tresorId string) error {
+			if tresorId == "slow" {
+				<-release
+				return nil
+			}
+			fast <- tresorId
+			return nil
+		},
+	}
+	o := New(fake, newBoltStore(t), WithWorkers(2), WithPollInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, tresorId := range []string{"slow", "a", "b", "c"} {
+		o.Enqueue(ctx, ApproveTresorCreation(tresorId, tresorId))
+		// the slow operation is claimed first
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() { done <- o.Run(ctx) }()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-fast:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the other operations to be executed meanwhile, got %d", i)
+		}
+	}
+	close(release)
+	cancel()
+	<-done
+}
+
+type tenantFunc func(req *http.Request) (*http.Response, error)
+
+func (f tenantFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestOutboxCompletesOperationsDoneBefore(t *testing.T) {
+	client, err := zerokit.NewZeroKitAdminApiClient("https://example.api.tresorit.io",
+		"admin@example.tresorit.io", "204bcf1b",
+		zerokit.WithAlreadyDoneCodes("TresorAlreadyApproved"),
+		zerokit.WithHttpClient(tenantFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body: ioutil.NopCloser(strings.NewReader(
+					`{"ErrorCode":"TresorAlreadyApproved"}`)),
+			}, nil
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, s, _ := newTestOutbox(t, client)
+	o.Enqueue(context.Background(), ApproveTresorCreation("a", "tresor1"))
+
+	process(t, o)
+	if op := state(t, s, "a"); op.State != StateDone {
+		t.Errorf("expected a to be done, was = %+v", op)
+	}
+}
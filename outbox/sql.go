@@ -0,0 +1,272 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTable is the table of the SQLStore.
+const DefaultTable = "zerokit_outbox"
+
+// SQLStore is a Store kept in a table of a SQL database, which may be
+// shared by several processes. Claims are made with optimistic locking, so
+// that an operation is claimed by one process at a time.
+type SQLStore struct {
+	db     *sql.DB
+	table  string
+	dollar bool
+}
+
+// SQLOption configures optional behaviour of the SQLStore.
+type SQLOption func(*SQLStore)
+
+// WithTable sets the table the operations are kept in. Defaults to
+// DefaultTable.
+func WithTable(table string) SQLOption {
+	return func(s *SQLStore) {
+		s.table = table
+	}
+}
+
+// WithDollarPlaceholders uses the $1, $2, ... placeholders of PostgreSQL
+// instead of ?.
+func WithDollarPlaceholders() SQLOption {
+	return func(s *SQLStore) {
+		s.dollar = true
+	}
+}
+
+// NewSQLStore creates a store in db. The table must have been created with
+// CreateTable.
+func NewSQLStore(db *sql.DB, opts ...SQLOption) *SQLStore {
+	s := &SQLStore{db: db, table: DefaultTable}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// CreateTable creates the table of the store, unless it exists.
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.query(`CREATE TABLE IF NOT EXISTS {table} (
+		op_key VARCHAR(255) PRIMARY KEY,
+		state VARCHAR(16) NOT NULL,
+		next_attempt BIGINT NOT NULL,
+		updated BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`))
+	return err
+}
+
+// query substitutes the table name and, if needed, numbers the ?
+// placeholders.
+func (s *SQLStore) query(q string) string {
+	q = strings.Replace(q, "{table}", s.table, -1)
+	if !s.dollar {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Add stores the operation, unless an operation with its key exists.
+func (s *SQLStore) Add(ctx context.Context, op Operation) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.query(
+		`INSERT INTO {table} (op_key, state, next_attempt, updated, data)
+		VALUES (?, ?, ?, ?, ?)`),
+		op.Key, string(op.State), op.NextAttempt.UnixNano(), op.Updated.UnixNano(), string(data))
+	if err == nil {
+		return nil
+	}
+	// the key may have been added concurrently, which is not reported
+	// uniformly by the drivers
+	if _, getErr := s.Get(ctx, op.Key); getErr == nil {
+		return nil
+	}
+	return err
+}
+
+// Get returns the operation with the key, or ErrNotFound.
+func (s *SQLStore) Get(ctx context.Context, key string) (*Operation, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.query(
+		`SELECT data FROM {table} WHERE op_key = ?`), key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var op Operation
+	if err := json.Unmarshal([]byte(data), &op); err != nil {
+		return nil, fmt.Errorf("operation %s: %w", key, err)
+	}
+	return &op, nil
+}
+
+// Claim returns up to limit pending operations due at now, postponing them
+// by lease. Operations claimed concurrently by another process are skipped.
+func (s *SQLStore) Claim(ctx context.Context, now time.Time, lease time.Duration,
+	limit int) ([]Operation, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(
+		`SELECT data FROM {table} WHERE state = ? AND next_attempt <= ?
+		ORDER BY next_attempt LIMIT ?`),
+		string(StatePending), now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	var due []Operation
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var op Operation
+		if err := json.Unmarshal([]byte(data), &op); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, op)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := due[:0]
+	for _, op := range due {
+		claimedAt := op.NextAttempt
+		op.Attempts++
+		op.NextAttempt = now.Add(lease)
+		op.Updated = now
+		ok, err := s.put(ctx, &op, claimedAt)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			claimed = append(claimed, op)
+		}
+	}
+	return claimed, nil
+}
+
+// Complete marks the operation as done.
+func (s *SQLStore) Complete(ctx context.Context, key string, now time.Time) error {
+	return s.update(ctx, key, func(op *Operation) {
+		op.State, op.LastError, op.Updated = StateDone, "", now
+	})
+}
+
+// Retry schedules the next attempt of the operation.
+func (s *SQLStore) Retry(ctx context.Context, key string, next time.Time,
+	lastErr string) error {
+	return s.update(ctx, key, func(op *Operation) {
+		op.NextAttempt, op.LastError = next, lastErr
+	})
+}
+
+// Bury marks the operation as dead.
+func (s *SQLStore) Bury(ctx context.Context, key string, now time.Time,
+	lastErr string) error {
+	return s.update(ctx, key, func(op *Operation) {
+		op.State, op.LastError, op.Updated = StateDead, lastErr, now
+	})
+}
+
+// Prune deletes the done and dead operations last updated before the given
+// time.
+func (s *SQLStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, s.query(
+		`DELETE FROM {table} WHERE state <> ? AND updated < ?`),
+		string(StatePending), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// update applies fn to the operation. It is retried if the operation is
+// changed concurrently.
+func (s *SQLStore) update(ctx context.Context, key string, fn func(*Operation)) error {
+	for {
+		op, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		nextAttempt := op.NextAttempt
+		fn(op)
+		ok, err := s.put(ctx, op, nextAttempt)
+		if err != nil || ok {
+			return err
+		}
+	}
+}
+
+// put writes the operation, if its next attempt is still the given one, and
+// reports whether it has been written.
+func (s *SQLStore) put(ctx context.Context, op *Operation, nextAttempt time.Time) (bool, error) {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, s.query(
+		`UPDATE {table} SET state = ?, next_attempt = ?, updated = ?, data = ?
+		WHERE op_key = ? AND next_attempt = ?`),
+		string(op.State), op.NextAttempt.UnixNano(), op.Updated.UnixNano(),
+		string(data), op.Key, nextAttempt.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,217 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newBoltStore(t *testing.T) Store {
+	s, err := OpenBoltStore(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newSQLStore(t *testing.T) Store {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := NewSQLStore(db)
+	if err := s.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var stores = map[string]func(t *testing.T) Store{
+	"bolt": newBoltStore,
+	"sql":  newSQLStore,
+}
+
+func pending(op Operation, at time.Time) Operation {
+	op.State = StatePending
+	op.NextAttempt, op.Created, op.Updated = at, at, at
+	return op
+}
+
+func TestStore(t *testing.T) {
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, newStore(t))
+		})
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, op := range []Operation{
+		ApproveTresorCreation("a", "tresor1"),
+		ApproveShare("b", "op1"),
+		ApproveKick("c", "op2"),
+	} {
+		if err := s.Add(ctx, pending(op, now.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatalf("add must not fail, was = %v", err)
+		}
+	}
+	if err := s.Add(ctx, pending(ApproveShare("a", "op3"), now)); err != nil {
+		t.Fatalf("add of an existing key must not fail, was = %v", err)
+	}
+	if op, _ := s.Get(ctx, "a"); op == nil || op.Kind != KindApproveTresorCreation {
+		t.Errorf("existing operation must be kept, was = %+v", op)
+	}
+	if _, err := s.Get(ctx, "x"); err != ErrNotFound {
+		t.Errorf("err = %v, want = %v", err, ErrNotFound)
+	}
+
+	claimed, err := s.Claim(ctx, now.Add(time.Second), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("claim must not fail, was = %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].Key != "a" || claimed[1].Key != "b" {
+		t.Fatalf("expected a and b to be claimed, got %+v", claimed)
+	}
+	if claimed[0].Attempts != 1 || !claimed[0].NextAttempt.Equal(now.Add(time.Second+time.Minute)) {
+		t.Errorf("unexpected claimed operation: %+v", claimed[0])
+	}
+	if again, _ := s.Claim(ctx, now.Add(2*time.Second), time.Minute, 10); len(again) != 1 ||
+		again[0].Key != "c" {
+		t.Errorf("expected only c to be claimed, got %+v", again)
+	}
+
+	if err := s.Complete(ctx, "a", now.Add(3*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Retry(ctx, "b", now.Add(10*time.Second), "unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Bury(ctx, "c", now.Add(3*time.Second), "bad request"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Complete(ctx, "x", now); err != ErrNotFound {
+		t.Errorf("err = %v, want = %v", err, ErrNotFound)
+	}
+
+	if op, _ := s.Get(ctx, "a"); op.State != StateDone {
+		t.Errorf("expected a to be done, was = %+v", op)
+	}
+	if op, _ := s.Get(ctx, "b"); op.State != StatePending || op.LastError != "unavailable" {
+		t.Errorf("expected b to be retried, was = %+v", op)
+	}
+	if op, _ := s.Get(ctx, "c"); op.State != StateDead || op.LastError != "bad request" {
+		t.Errorf("expected c to be dead, was = %+v", op)
+	}
+
+	// the lease of b has been replaced by its retry
+	if due, _ := s.Claim(ctx, now.Add(9*time.Second), time.Minute, 10); len(due) != 0 {
+		t.Errorf("expected nothing to be due, got %+v", due)
+	}
+	if due, _ := s.Claim(ctx, now.Add(10*time.Second), time.Minute, 10); len(due) != 1 ||
+		due[0].Attempts != 2 {
+		t.Errorf("expected b to be claimed again, got %+v", due)
+	}
+
+	n, err := s.Prune(ctx, now.Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Errorf("expected a and c to be pruned, got %d, %v", n, err)
+	}
+	if _, err := s.Get(ctx, "b"); err != nil {
+		t.Errorf("pending operation must not be pruned, was = %v", err)
+	}
+}
+
+func lastTxId(t *testing.T, s *BoltStore) int {
+	var id int
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		id = tx.ID()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestBoltStoreIdleClaimDoesNotWrite(t *testing.T) {
+	s := newBoltStore(t).(*BoltStore)
+	ctx := context.Background()
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Add(ctx, pending(ApproveShare("a", "op1"), now.Add(time.Minute)))
+
+	before := lastTxId(t, s)
+	if due, err := s.Claim(ctx, now, time.Minute, 10); err != nil || len(due) != 0 {
+		t.Fatalf("expected nothing to be due, got %+v, %v", due, err)
+	}
+	if after := lastTxId(t, s); after != before {
+		t.Errorf("idle claim must not write, transactions = %d, want = %d",
+			after, before)
+	}
+}
+
+func TestBoltStoreIndexesExistingOperations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Add(ctx, pending(ApproveShare("a", "op1"), now))
+	// a file written before the index was introduced
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(boltDueBucket)
+	})
+	s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("cannot open store: %v", err)
+	}
+	defer s.Close()
+	if due, _ := s.Claim(ctx, now, time.Minute, 10); len(due) != 1 {
+		t.Errorf("expected a to be claimed, got %+v", due)
+	}
+}
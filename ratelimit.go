@@ -0,0 +1,216 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits the requests sent to the admin API to rate requests
+// per second on average, allowing bursts of up to burst requests. When the
+// tenant responds with 429 Too Many Requests, the rate is halved and no
+// requests are sent until the Retry-After delay has passed; it recovers
+// gradually with every successful request afterwards. A rate which is not
+// positive removes the limit.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		if rate <= 0 {
+			c.limiter = nil
+			return
+		}
+		c.limiter = newTokenBucket(rate, burst)
+	}
+}
+
+// WithMaxInFlight limits the number of requests awaiting a response from the
+// admin API at the same time. A limit which is not positive removes it.
+func WithMaxInFlight(n int) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		if n <= 0 {
+			c.inFlight = nil
+			return
+		}
+		c.inFlight = make(chan struct{}, n)
+	}
+}
+
+// throttle waits until the request may be sent according to the rate limit
+// and the maximum number of requests in flight. The returned function must be
+// called with the response, if any, once the request has completed.
+func (c *ZeroKitAdminApiClient) throttle(
+	ctx context.Context) (func(*http.Response), error) {
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if c.inFlight != nil {
+		select {
+		case c.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return func(resp *http.Response) {
+		if c.inFlight != nil {
+			<-c.inFlight
+		}
+		if c.limiter == nil || resp == nil {
+			return
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			c.limiter.throttled(parseRetryAfter(resp.Header.Get("Retry-After"),
+				c.limiter.now()))
+		} else {
+			c.limiter.succeeded()
+		}
+	}, nil
+}
+
+// tokenBucket is a token bucket rate limiter, whose rate is decreased
+// multiplicatively on 429 responses and increased additively on successful
+// ones, up to the configured rate.
+type tokenBucket struct {
+	mu           sync.Mutex
+	rate         float64
+	maxRate      float64
+	burst        float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	now          func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:    rate,
+		maxRate: rate,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		now:     time.Now,
+	}
+}
+
+// wait takes a token from the bucket, waiting for it to be refilled if
+// necessary, unless the context is done first.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := b.now()
+		b.refill(now)
+		var delay time.Duration
+		switch {
+		case now.Before(b.blockedUntil):
+			delay = b.blockedUntil.Sub(now)
+		case b.tokens >= 1:
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		default:
+			delay = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		}
+		b.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) throttled(retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate /= 2
+	if min := b.maxRate / 32; b.rate < min {
+		b.rate = min
+	}
+	b.tokens = 0
+	if until := b.now().Add(retryAfter); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+func (b *tokenBucket) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate += b.maxRate / 16
+	if b.rate > b.maxRate {
+		b.rate = b.maxRate
+	}
+}
+
+func (b *tokenBucket) currentRate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, which is given
+// either in seconds or as an HTTP date. It is zero if the header is missing
+// or malformed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitWaitsForTokens(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":[]}`), nil
+	}, WithRateLimit(20, 2))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := c.ListTresorMembers("xyz"); err != nil {
+			t.Fatalf("list tresor members must not fail, was = %v", err)
+		}
+	}
+	// the burst of two is free, the other two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("4 requests at 20/s with burst 2 took %v, want >= 100ms", elapsed)
+	}
+}
+
+func TestRateLimitHonorsContext(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":[]}`), nil
+	}, WithRateLimit(0.01, 1))
+
+	c.ListTresorMembers("xyz")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.ListTresorMembersContext(ctx, "xyz")
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want = %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return response(http.StatusOK, `{"Members":[]}`), nil
+	}, WithMaxInFlight(2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ListTresorMembers("xyz")
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight != 2 {
+		t.Errorf("max requests in flight = %d, want = %d", maxInFlight, 2)
+	}
+}
+
+func TestMaxInFlightHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		<-release
+		return response(http.StatusOK, `{"Members":[]}`), nil
+	}, WithMaxInFlight(1))
+
+	go c.ListTresorMembers("xyz")
+	defer close(release)
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.ListTresorMembersContext(ctx, "xyz")
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want = %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRateLimitAdaptsToTooManyRequests(t *testing.T) {
+	status := http.StatusTooManyRequests
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		resp := response(status, "")
+		resp.Header = http.Header{"Retry-After": {"1"}}
+		return resp, nil
+	}, WithRateLimit(100, 10))
+
+	now := time.Now()
+	c.limiter.now = func() time.Time { return now }
+
+	c.ListTresorMembers("xyz")
+	if rate := c.limiter.currentRate(); rate != 50 {
+		t.Errorf("rate after 429 = %v, want = %v", rate, 50)
+	}
+	if !c.limiter.blockedUntil.Equal(now.Add(time.Second)) {
+		t.Errorf("blocked until = %v, want = %v",
+			c.limiter.blockedUntil, now.Add(time.Second))
+	}
+
+	c.limiter.throttled(0)
+	c.limiter.throttled(0)
+	c.limiter.throttled(0)
+	c.limiter.throttled(0)
+	c.limiter.throttled(0)
+	if rate := c.limiter.currentRate(); rate != 100.0/32 {
+		t.Errorf("rate must not drop below %v, was = %v", 100.0/32, rate)
+	}
+
+	for i := 0; i < 20; i++ {
+		c.limiter.succeeded()
+	}
+	if rate := c.limiter.currentRate(); rate != 100 {
+		t.Errorf("rate after recovery = %v, want = %v", rate, 100)
+	}
+}
+
+func TestNonPositiveLimitsAreRemoved(t *testing.T) {
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, `{"Members":[]}`), nil
+	}, WithRateLimit(0, 5), WithMaxInFlight(-1))
+	if c.limiter != nil || c.inFlight != nil {
+		t.Fatal("non-positive limits must remove the limit")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		if _, err := c.ListTresorMembersContext(ctx, "xyz"); err != nil {
+			t.Fatalf("err = %v, want = nil", err)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Thu, 01 Jun 2017 12:00:30 GMT": 30 * time.Second,
+		"Thu, 01 Jun 2017 11:00:00 GMT": 0,
+	}
+	for value, expected := range tests {
+		if d := parseRetryAfter(value, now); d != expected {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, d, expected)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MembershipDiff is the difference between the desired and the actual
+// members of a tresor.
+type MembershipDiff struct {
+	TresorId string `json:"tresorId"`
+	// Missing are the desired members which are not members of the tresor.
+	Missing []string `json:"missing,omitempty"`
+	// Extra are the members of the tresor which are not desired.
+	Extra []string `json:"extra,omitempty"`
+	// Error is set if the members of the tresor could not be listed.
+	Error string `json:"error,omitempty"`
+}
+
+// InSync reports whether the tresor has exactly the desired members.
+func (d MembershipDiff) InSync() bool {
+	return d.Error == "" && len(d.Missing) == 0 && len(d.Extra) == 0
+}
+
+// ReconcileReport lists the tresors whose members differ from the desired
+// ones, ordered by tresor id.
+type ReconcileReport struct {
+	Checked int              `json:"checked"`
+	Diffs   []MembershipDiff `json:"diffs"`
+}
+
+// InSync reports whether all tresors have exactly the desired members.
+func (r *ReconcileReport) InSync() bool {
+	return len(r.Diffs) == 0
+}
+
+// ReconcileTresorMembers compares the desired members of every tresor, given
+// as a map from tresor id to user ids, with the actual ones. At most
+// concurrency tresors are listed at the same time. Errors listing a tresor
+// are part of the report; an error is only returned if the context is done
+// before all tresors have been checked.
+func (c *ZeroKitAdminApiClient) ReconcileTresorMembers(ctx context.Context,
+	desired map[string][]string, concurrency int) (*ReconcileReport, error) {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var (
+		mu     sync.Mutex
+		report = &ReconcileReport{Checked: len(desired), Diffs: []MembershipDiff{}}
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, concurrency)
+	)
+	for tresorId, members := range desired {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(tresorId string, members []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			diff := c.diffTresorMembers(ctx, tresorId, members)
+			if diff.InSync() {
+				return
+			}
+			mu.Lock()
+			report.Diffs = append(report.Diffs, diff)
+			mu.Unlock()
+		}(tresorId, members)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report.Diffs, func(i, j int) bool {
+		return report.Diffs[i].TresorId < report.Diffs[j].TresorId
+	})
+	return report, nil
+}
+
+func (c *ZeroKitAdminApiClient) diffTresorMembers(ctx context.Context,
+	tresorId string, desired []string) MembershipDiff {
+	diff := MembershipDiff{TresorId: tresorId}
+	actual, err := c.ListTresorMembersContext(ctx, tresorId)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	diff.Missing = difference(desired, actual)
+	diff.Extra = difference(actual, desired)
+	return diff
+}
+
+// difference returns the sorted, unique elements of a which are not in b.
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var diff []string
+	for _, s := range a {
+		if !in[s] {
+			in[s] = true
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
@@ -0,0 +1,96 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestReconcileTresorMembers(t *testing.T) {
+	actual := map[string][]string{
+		"tresor1": {"zk1", "zk2"},
+		"tresor2": {"zk1", "zk3"},
+		"tresor3": {"zk2"},
+	}
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		members, ok := actual[req.URL.Query().Get("tresorid")]
+		if !ok {
+			return response(http.StatusNotFound,
+				`{"ErrorCode":"TresorNotExists","ErrorMessage":"no such tresor"}`), nil
+		}
+		body, _ := json.Marshal(map[string][]string{"Members": members})
+		return response(http.StatusOK, string(body)), nil
+	})
+
+	report, err := c.ReconcileTresorMembers(context.Background(), map[string][]string{
+		"tresor1": {"zk2", "zk1"},
+		"tresor2": {"zk1", "zk2", "zk2"},
+		"tresor3": {},
+		"tresor4": {"zk1"},
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || report.InSync() {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	want := []MembershipDiff{
+		{TresorId: "tresor2", Missing: []string{"zk2"}, Extra: []string{"zk3"}},
+		{TresorId: "tresor3", Extra: []string{"zk2"}},
+	}
+	if !reflect.DeepEqual(report.Diffs[:2], want) {
+		t.Errorf("diffs = %+v, want = %+v", report.Diffs[:2], want)
+	}
+	if len(report.Diffs) != 3 || report.Diffs[2].TresorId != "tresor4" ||
+		report.Diffs[2].Error == "" {
+		t.Errorf("expected listing tresor4 to fail, got %+v", report.Diffs)
+	}
+}
+
+func TestReconcileTresorMembersCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestClient(t, func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return response(http.StatusOK, `{"Members":[]}`), nil
+	})
+
+	_, err := c.ReconcileTresorMembers(ctx, map[string][]string{
+		"tresor1": nil, "tresor2": nil,
+	}, 1)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
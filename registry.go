@@ -0,0 +1,362 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownTenant is returned for tenants missing in the registry config.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// TenantConfig configures the client of a single ZeroKit tenant. The admin
+// key is given either inline, or by the name of an environment variable or
+// the path of a file containing it.
+type TenantConfig struct {
+	ServiceUrl   string `json:"serviceUrl" yaml:"serviceUrl"`
+	AdminUserId  string `json:"adminUserId" yaml:"adminUserId"`
+	AdminKey     string `json:"adminKey,omitempty" yaml:"adminKey,omitempty"`
+	AdminKeyEnv  string `json:"adminKeyEnv,omitempty" yaml:"adminKeyEnv,omitempty"`
+	AdminKeyFile string `json:"adminKeyFile,omitempty" yaml:"adminKeyFile,omitempty"`
+}
+
+// RegistryConfig maps tenant ids, e.g. "staging" or "production-eu", to the
+// configuration of their clients.
+type RegistryConfig struct {
+	Tenants map[string]TenantConfig `json:"tenants" yaml:"tenants"`
+}
+
+// LoadRegistryConfig reads the registry configuration from a JSON file, if
+// its name ends with .json, or from a YAML file otherwise.
+func LoadRegistryConfig(path string) (*RegistryConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config RegistryConfig
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, &config)
+	} else {
+		err = yaml.Unmarshal(content, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// NewClient creates the client of the tenant.
+func (t TenantConfig) NewClient(opts ...Option) (*ZeroKitAdminApiClient, error) {
+	keys, err := t.keyProvider()
+	if err != nil {
+		return nil, err
+	}
+	return NewZeroKitAdminApiClientWithKeyProvider(t.ServiceUrl, t.AdminUserId,
+		keys, opts...)
+}
+
+func (t TenantConfig) keyProvider() (KeyProvider, error) {
+	switch {
+	case t.AdminKey != "":
+		return newStaticKey(t.AdminKey)
+	case t.AdminKeyEnv != "":
+		return NewEnvKeyProvider(t.AdminKeyEnv)
+	case t.AdminKeyFile != "":
+		return NewFileKeyProvider(t.AdminKeyFile)
+	}
+	return nil, errors.New("no admin key configured")
+}
+
+// ClientRegistry holds the clients of several ZeroKit tenants, which share a
+// single HTTP transport, and routes the calls by tenant id.
+type ClientRegistry struct {
+	opts []Option
+	path string
+
+	// reloadMu serializes the changes of the clients.
+	reloadMu sync.Mutex
+
+	mu      sync.RWMutex
+	configs map[string]TenantConfig
+	clients map[string]*registeredClient
+	modTime time.Time
+}
+
+// registeredClient counts the users of a client, so that a replaced client
+// is closed only once the last of them is done.
+type registeredClient struct {
+	client  *ZeroKitAdminApiClient
+	users   int
+	retired bool
+}
+
+// NewClientRegistry creates the clients of all tenants in the config. The
+// options are applied to every client.
+func NewClientRegistry(config *RegistryConfig,
+	opts ...Option) (*ClientRegistry, error) {
+	shared := &http.Client{
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	r := &ClientRegistry{
+		opts: append([]Option{WithHttpClient(shared)}, opts...),
+	}
+	if err := r.apply(config); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewClientRegistryFromFile is like NewClientRegistry, but reads the config
+// from the file at path, which is read again by Reload and Watch.
+func NewClientRegistryFromFile(path string,
+	opts ...Option) (*ClientRegistry, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadRegistryConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewClientRegistry(config, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.path, r.modTime = path, fi.ModTime()
+	return r, nil
+}
+
+// apply replaces the clients by the ones of the given config. The clients of
+// tenants whose configuration did not change are kept, the replaced ones are
+// retired. If a client cannot be created, the registry is left as it was.
+// It is called with reloadMu held.
+func (r *ClientRegistry) apply(config *RegistryConfig) error {
+	r.mu.RLock()
+	current, currentConfigs := r.clients, r.configs
+	r.mu.RUnlock()
+
+	clients := make(map[string]*registeredClient, len(config.Tenants))
+	kept := make(map[*registeredClient]bool, len(current))
+	for tenant, tc := range config.Tenants {
+		if rc, ok := current[tenant]; ok && currentConfigs[tenant] == tc {
+			clients[tenant] = rc
+			kept[rc] = true
+			continue
+		}
+		c, err := tc.NewClient(r.opts...)
+		if err != nil {
+			for _, rc := range clients {
+				if !kept[rc] {
+					rc.client.Close()
+				}
+			}
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		clients[tenant] = &registeredClient{client: c}
+	}
+
+	configs := make(map[string]TenantConfig, len(config.Tenants))
+	for tenant, tc := range config.Tenants {
+		configs[tenant] = tc
+	}
+
+	r.mu.Lock()
+	r.clients, r.configs = clients, configs
+	r.mu.Unlock()
+
+	for _, rc := range current {
+		if !kept[rc] {
+			r.retire(rc)
+		}
+	}
+	return nil
+}
+
+// retire closes the replaced client, or leaves it to its last user.
+func (r *ClientRegistry) retire(rc *registeredClient) {
+	r.mu.Lock()
+	rc.retired = true
+	unused := rc.users == 0
+	r.mu.Unlock()
+	if unused {
+		rc.client.Close()
+	}
+}
+
+// Client returns the client of the given tenant. The client is closed once
+// the tenant is removed or reconfigured, even if a call is still using it,
+// so it should be obtained again for every operation instead of being kept.
+// Do and Acquire keep the client open while it is used.
+func (r *ClientRegistry) Client(tenant string) (*ZeroKitAdminApiClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rc, ok := r.clients[tenant]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+	}
+	return rc.client, nil
+}
+
+// Acquire returns the client of the given tenant, which is kept open until
+// release is called, even if the tenant is removed or reconfigured in the
+// meantime. It suits long-lived users of a client, e.g. an outbox, which
+// keep the former configuration until they acquire the client again.
+func (r *ClientRegistry) Acquire(tenant string) (c *ZeroKitAdminApiClient,
+	release func(), err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rc, ok := r.clients[tenant]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+	}
+	rc.users++
+	var once sync.Once
+	return rc.client, func() { once.Do(func() { r.release(rc) }) }, nil
+}
+
+func (r *ClientRegistry) release(rc *registeredClient) {
+	r.mu.Lock()
+	rc.users--
+	unused := rc.retired && rc.users == 0
+	r.mu.Unlock()
+	if unused {
+		rc.client.Close()
+	}
+}
+
+// Do calls fn with the client of the given tenant, which is kept open until
+// fn returns, and returns the error of fn.
+func (r *ClientRegistry) Do(tenant string,
+	fn func(*ZeroKitAdminApiClient) error) error {
+	c, release, err := r.Acquire(tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(c)
+}
+
+// Tenants returns the sorted ids of the configured tenants.
+func (r *ClientRegistry) Tenants() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tenants := make([]string, 0, len(r.clients))
+	for tenant := range r.clients {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Reload reads the config file again and applies it. Removed or
+// reconfigured tenants are served by new clients afterwards, and their
+// former clients are closed, which wipes their admin keys, once the calls
+// of Do and the users of Acquire are done with them.
+func (r *ClientRegistry) Reload() error {
+	if r.path == "" {
+		return errors.New("registry has not been created from a file")
+	}
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	config, err := LoadRegistryConfig(r.path)
+	if err != nil {
+		return err
+	}
+	if err := r.apply(config); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.modTime = fi.ModTime()
+	r.mu.Unlock()
+	return nil
+}
+
+// Close closes the clients of all tenants, the ones still in use by Do or
+// Acquire once they are done. The registry cannot be used afterwards.
+func (r *ClientRegistry) Close() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	r.mu.Lock()
+	current := r.clients
+	r.clients, r.configs = nil, nil
+	r.mu.Unlock()
+	for _, rc := range current {
+		r.retire(rc)
+	}
+	return nil
+}
+
+// Watch checks the config file for changes every interval and reloads it,
+// until the context is done. Errors of a reload are passed to onError, if it
+// is not nil, and the previous configuration stays in effect.
+func (r *ClientRegistry) Watch(ctx context.Context, interval time.Duration,
+	onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(r.path)
+		if err == nil {
+			r.mu.RLock()
+			changed := !fi.ModTime().Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = r.Reload()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const registryYAML = `
+tenants:
+  staging:
+    serviceUrl: https://staging.api.tresorit.io
+    adminUserId: admin@staging.tresorit.io
+    adminKey: 204bcf1b
+  production:
+    serviceUrl: https://production.api.tresorit.io
+    adminUserId: admin@production.tresorit.io
+    adminKeyEnv: ZEROKIT_TEST_PRODUCTION_KEY
+`
+
+const registryJSON = `{
+  "tenants": {
+    "staging": {
+      "serviceUrl": "https://staging.api.tresorit.io",
+      "adminUserId": "admin@staging.tresorit.io",
+      "adminKey": "204bcf1b"
+    },
+    "eu": {
+      "serviceUrl": "https://eu.api.tresorit.io",
+      "adminUserId": "admin@eu.tresorit.io",
+      "adminKey": "a1b2c3d4e5f6"
+    }
+  }
+}`
+
+func writeRegistryConfig(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientRegistryFromYAML(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zerokit")
+	defer os.RemoveAll(dir)
+	os.Setenv("ZEROKIT_TEST_PRODUCTION_KEY", RotatedAdminKey)
+	defer os.Unsetenv("ZEROKIT_TEST_PRODUCTION_KEY")
+
+	path := writeRegistryConfig(t, dir, "tenants.yaml", registryYAML)
+	r, err := NewClientRegistryFromFile(path)
+	if err != nil {
+		t.Fatalf("cannot create registry: %v", err)
+	}
+
+	if !reflect.DeepEqual(r.Tenants(), []string{"production", "staging"}) {
+		t.Errorf("tenants = %v, want = %v",
+			r.Tenants(), []string{"production", "staging"})
+	}
+
+	staging, err := r.Client("staging")
+	if err != nil {
+		t.Fatalf("cannot get staging client: %v", err)
+	}
+	if staging.ServiceUrl.Host != "staging.api.tresorit.io" {
+		t.Errorf("staging host = %s", staging.ServiceUrl.Host)
+	}
+	production, _ := r.Client("production")
+	if production.adminUserId != "admin@production.tresorit.io" {
+		t.Errorf("production admin = %s", production.adminUserId)
+	}
+	if staging.httpClient != production.httpClient {
+		t.Error("clients must share the HTTP transport")
+	}
+
+	if _, err := r.Client("unknown"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("err = %v, want = %v", err, ErrUnknownTenant)
+	}
+}
+
+func TestClientRegistryReload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zerokit")
+	defer os.RemoveAll(dir)
+	os.Setenv("ZEROKIT_TEST_PRODUCTION_KEY", RotatedAdminKey)
+	defer os.Unsetenv("ZEROKIT_TEST_PRODUCTION_KEY")
+
+	path := writeRegistryConfig(t, dir, "tenants.yaml", registryYAML)
+	r, err := NewClientRegistryFromFile(path)
+	if err != nil {
+		t.Fatalf("cannot create registry: %v", err)
+	}
+	staging, _ := r.Client("staging")
+	production, _ := r.Client("production")
+
+	// JSON is valid YAML, so the changed file is read as it is
+	writeRegistryConfig(t, dir, "tenants.yaml", registryJSON)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("cannot reload registry: %v", err)
+	}
+	if !reflect.DeepEqual(r.Tenants(), []string{"eu", "staging"}) {
+		t.Errorf("tenants = %v, want = %v", r.Tenants(), []string{"eu", "staging"})
+	}
+	if c, _ := r.Client("staging"); c != staging {
+		t.Error("unchanged tenant must keep its client")
+	}
+	if err := production.ApproveTresorCreation("xyz"); err != ErrAdminKeyWiped {
+		t.Errorf("client of removed tenant: err = %v, want = %v",
+			err, ErrAdminKeyWiped)
+	}
+
+	writeRegistryConfig(t, dir, "tenants.yaml",
+		"tenants:\n  eu:\n    serviceUrl: https://eu.api.tresorit.io\n")
+	if err := r.Reload(); err == nil {
+		t.Error("expected error for tenant without admin key")
+	}
+	if !reflect.DeepEqual(r.Tenants(), []string{"eu", "staging"}) {
+		t.Errorf("failed reload must keep the tenants, was = %v", r.Tenants())
+	}
+
+	r.Close()
+	if err := staging.ApproveTresorCreation("xyz"); err != ErrAdminKeyWiped {
+		t.Errorf("client of closed registry: err = %v, want = %v",
+			err, ErrAdminKeyWiped)
+	}
+}
+
+func TestClientRegistryWatch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zerokit")
+	defer os.RemoveAll(dir)
+
+	path := writeRegistryConfig(t, dir, "tenants.json", registryJSON)
+	r, err := NewClientRegistryFromFile(path)
+	if err != nil {
+		t.Fatalf("cannot create registry: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		r.Watch(ctx, 10*time.Millisecond, func(err error) {
+			t.Errorf("reload must not fail, was = %v", err)
+		})
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	writeRegistryConfig(t, dir, "tenants.json",
+		`{"tenants":{"staging":{"serviceUrl":"https://staging.api.tresorit.io",`+
+			`"adminUserId":"admin@staging.tresorit.io","adminKey":"204bcf1b"}}}`)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.Tenants()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !reflect.DeepEqual(r.Tenants(), []string{"staging"}) {
+		t.Errorf("tenants = %v, want = %v", r.Tenants(), []string{"staging"})
+	}
+}
+
+func TestLoadRegistryConfigInvalidJSON(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zerokit")
+	defer os.RemoveAll(dir)
+
+	path := writeRegistryConfig(t, dir, "tenants.json", "tenants: {}")
+	if _, err := LoadRegistryConfig(path); err == nil {
+		t.Error("expected error for invalid JSON config")
+	}
+}
+
+func isWiped(c *ZeroKitAdminApiClient) bool {
+	_, err := c.keys.AdminKey()
+	return err == ErrAdminKeyWiped
+}
+
+func TestClientRegistryKeepsReplacedClientInUse(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zerokit")
+	defer os.RemoveAll(dir)
+
+	path := writeRegistryConfig(t, dir, "tenants.json", registryJSON)
+	r, err := NewClientRegistryFromFile(path)
+	if err != nil {
+		t.Fatalf("cannot create registry: %v", err)
+	}
+	held, release, err := r.Acquire("eu")
+	if err != nil {
+		t.Fatalf("cannot acquire eu client: %v", err)
+	}
+
+	var replaced *ZeroKitAdminApiClient
+	err = r.Do("staging", func(c *ZeroKitAdminApiClient) error {
+		replaced = c
+		writeRegistryConfig(t, dir, "tenants.json",
+			`{"tenants":{"staging":{"serviceUrl":"https://staging.api.tresorit.io",`+
+				`"adminUserId":"admin@staging.tresorit.io","adminKey":"3f2e1d0c"}}}`)
+		if err := r.Reload(); err != nil {
+			return err
+		}
+		if isWiped(c) {
+			t.Error("client must be kept open during the call")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot reload registry: %v", err)
+	}
+	if !isWiped(replaced) {
+		t.Error("replaced client must be closed after the call")
+	}
+	if c, _ := r.Client("staging"); c == replaced || isWiped(c) {
+		t.Error("reconfigured tenant must get a new client")
+	}
+
+	if isWiped(held) {
+		t.Error("acquired client must be kept open")
+	}
+	release()
+	release()
+	if !isWiped(held) {
+		t.Error("removed client must be closed once released")
+	}
+
+	if err := r.Do("eu", func(*ZeroKitAdminApiClient) error {
+		return nil
+	}); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("err = %v, want = %v", err, ErrUnknownTenant)
+	}
+}
+
+func TestClientRegistryConcurrentReloads(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "zerokit")
+	defer os.RemoveAll(dir)
+
+	path := writeRegistryConfig(t, dir, "tenants.json", registryJSON)
+	r, err := NewClientRegistryFromFile(path)
+	if err != nil {
+		t.Fatalf("cannot create registry: %v", err)
+	}
+
+	var mu sync.Mutex
+	var created []*ZeroKitAdminApiClient
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				writeRegistryConfig(t, dir, "tenants.json", fmt.Sprintf(
+					`{"tenants":{"staging":{"serviceUrl":"https://staging.api.tresorit.io",`+
+						`"adminUserId":"admin@staging.tresorit.io","adminKey":"%02x%02x"}}}`, i, j))
+				if err := r.Reload(); err != nil {
+					// a concurrent write may leave the file empty for a moment
+					continue
+				}
+				c, _ := r.Client("staging")
+				mu.Lock()
+				created = append(created, c)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	current, _ := r.Client("staging")
+	for _, c := range created {
+		if c != current && !isWiped(c) {
+			t.Fatal("replaced client must be closed")
+		}
+	}
+	r.Close()
+	if !isWiped(current) {
+		t.Error("client of closed registry must be closed")
+	}
+}
@@ -0,0 +1,188 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// KeyRotationEvent is emitted when a request signed with the primary admin
+// key was rejected by the tenant, but succeeded with the secondary key, which
+// thereby became the primary one.
+type KeyRotationEvent struct {
+	AdminUserId string
+	// Fingerprint identifies the promoted key without revealing it. It is
+	// the hex encoded first eight bytes of the SHA256 of the key.
+	Fingerprint string
+	Time        time.Time
+}
+
+// WithSecondaryAdminKey sets the admin key to fall back to when the tenant
+// rejects the signature made with the primary key, e.g. because the admin key
+// has been rotated in the management portal.
+func WithSecondaryAdminKey(adminKey string) Option {
+	keys, err := newStaticKey(adminKey)
+	if err != nil {
+		return WithSecondaryKeyProvider(invalidKey{err})
+	}
+	return WithSecondaryKeyProvider(keys)
+}
+
+// invalidKey reports the error of an admin key passed to an Option, which
+// cannot return errors itself, once the client validates its keys.
+type invalidKey struct {
+	err error
+}
+
+func (k invalidKey) AdminKey() ([]byte, error) {
+	return nil, k.err
+}
+
+// WithSecondaryKeyProvider is like WithSecondaryAdminKey, but loads the
+// secondary admin key from the given KeyProvider.
+func WithSecondaryKeyProvider(keys KeyProvider) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.secondary = keys
+	}
+}
+
+// WithKeyRotationHandler sets the function called when the secondary admin
+// key has been promoted to be the primary one.
+func WithKeyRotationHandler(fn func(KeyRotationEvent)) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		c.onRotate = fn
+	}
+}
+
+// isSignatureRejection reports whether the tenant refused to authenticate the
+// request, which is how a signature made with a revoked admin key shows up.
+func isSignatureRejection(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized
+}
+
+// do signs and sends the request. The prepare function, if any, is applied
+// to the signed request right before it is sent. If the tenant rejects the
+// signature and a secondary admin key is configured, the request is signed
+// and sent again with the secondary key, which is promoted to be the primary
+// one once the tenant accepts it.
+func (c *ZeroKitAdminApiClient) do(req *http.Request,
+	prepare func(*http.Request)) (*http.Response, error) {
+	header := cloneHeader(req.Header)
+	primary, secondary := c.signingKeys()
+
+	resp, err := c.signWithAndDo(req, primary, prepare)
+	if err != nil || secondary == nil || !isSignatureRejection(resp) {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// the body has been consumed and cannot be sent again
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	req.Header = header
+	if req.GetBody != nil {
+		req.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err = c.signWithAndDo(req, secondary, prepare)
+	if err == nil && !isSignatureRejection(resp) {
+		c.promote(primary, secondary)
+	}
+	return resp, err
+}
+
+func (c *ZeroKitAdminApiClient) signWithAndDo(req *http.Request,
+	keys KeyProvider, prepare func(*http.Request)) (*http.Response, error) {
+	err := c.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	// wait before signing, so that TresoritDate is the time of sending
+	done, err := c.throttle(req.Context())
+	if err != nil {
+		c.breaker.cancel()
+		return nil, err
+	}
+	err = c.signWith(req, keys)
+	if err != nil {
+		done(nil)
+		c.breaker.cancel()
+		return nil, err
+	}
+	if prepare != nil {
+		prepare(req)
+	}
+	resp, err := c.httpClient.Do(req)
+	done(resp)
+	c.breaker.done(resp, err)
+	return resp, err
+}
+
+// promote swaps the primary and the secondary admin key, unless a concurrent
+// request has done so already. The former primary key is kept as secondary,
+// so that a rotation which is rolled back is handled the same way.
+func (s *requestSigner) promote(primary, secondary KeyProvider) {
+	s.mu.Lock()
+	if s.keys != primary || s.secondary != secondary {
+		s.mu.Unlock()
+		return
+	}
+	s.keys, s.secondary = secondary, primary
+	onRotate := s.onRotate
+	s.mu.Unlock()
+
+	if onRotate == nil {
+		return
+	}
+	event := KeyRotationEvent{AdminUserId: s.adminUserId, Time: time.Now().UTC()}
+	useAdminKey(secondary, func(key []byte) {
+		sum := sha256.Sum256(key)
+		event.Fingerprint = hex.EncodeToString(sum[:8])
+	})
+	onRotate(event)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,206 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const RotatedAdminKey = "a1b2c3d4e5f6"
+
+// verifySignature recomputes the signature of the request the same way the
+// tenant does, from the headers listed in HMACHeaders.
+func verifySignature(req *http.Request, adminKey string) bool {
+	var buffer bytes.Buffer
+	buffer.WriteString(req.Method + "\n")
+	buffer.WriteString(strings.TrimPrefix(req.URL.Path, "/"))
+	if req.URL.RawQuery != "" {
+		buffer.WriteString("?" + req.URL.RawQuery)
+	}
+	for _, key := range strings.Split(header(req, "HMACHeaders"), ",") {
+		buffer.WriteString("\n" + key + ":" + header(req, key))
+	}
+	key, err := decodeAdminKey(adminKey)
+	if err != nil {
+		return false
+	}
+	sig := computeHmacSHA256(buffer.Bytes(), key)
+	return header(req, "Authorization") ==
+		"AdminKey "+base64.StdEncoding.EncodeToString(sig)
+}
+
+// tenantAccepting returns a mock tenant which only accepts requests signed
+// with the given admin key and records the bodies of accepted requests.
+func tenantAccepting(adminKey *string, bodies *[]string) *mockHttpClient {
+	return &mockHttpClient{
+		DoMock: func(req *http.Request) (*http.Response, error) {
+			if !verifySignature(req, *adminKey) {
+				return &http.Response{
+					StatusCode: http.StatusUnauthorized,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+				}, nil
+			}
+			if req.Body != nil {
+				body, _ := ioutil.ReadAll(req.Body)
+				*bodies = append(*bodies, string(body))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			}, nil
+		},
+	}
+}
+
+func TestRotationPromotesSecondaryKey(t *testing.T) {
+	tenantKey := RotatedAdminKey
+	var bodies []string
+	var events []KeyRotationEvent
+
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithSecondaryAdminKey(RotatedAdminKey),
+		WithKeyRotationHandler(func(e KeyRotationEvent) {
+			events = append(events, e)
+		}),
+	)
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = tenantAccepting(&tenantKey, &bodies)
+
+	err = c.ApproveTresorCreation("xyz")
+	if err != nil {
+		t.Errorf("approve tresor creation must not fail, was = %v", err)
+	}
+	if len(bodies) != 1 || bodies[0] != `{"TresorId":"xyz"}` {
+		t.Errorf("bodies received by tenant = %q, want the re-signed body", bodies)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("number of rotation events = %d, want = %d", len(events), 1)
+	}
+	if events[0].AdminUserId != AdminUserId || events[0].Fingerprint == "" {
+		t.Errorf("unexpected rotation event %+v", events[0])
+	}
+
+	primary, secondary := c.signingKeys()
+	if key, _ := primary.AdminKey(); hex.EncodeToString(key) != RotatedAdminKey {
+		t.Errorf("primary key = %x, want = %s", key, RotatedAdminKey)
+	}
+	if key, _ := secondary.AdminKey(); hex.EncodeToString(key) != AdminKey {
+		t.Errorf("secondary key = %x, want = %s", key, AdminKey)
+	}
+
+	// subsequent requests are signed with the promoted key right away
+	c.httpClient = &mockHttpClient{
+		DoMock: func(req *http.Request) (*http.Response, error) {
+			if !verifySignature(req, RotatedAdminKey) {
+				t.Error("request is not signed with the promoted key")
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			}, nil
+		},
+	}
+	c.ListTresorMembers("xyz")
+	if len(events) != 1 {
+		t.Errorf("number of rotation events = %d, want = %d", len(events), 1)
+	}
+}
+
+func TestRotationWithoutSecondaryKey(t *testing.T) {
+	tenantKey := RotatedAdminKey
+	var bodies []string
+
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey)
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = tenantAccepting(&tenantKey, &bodies)
+
+	r, _ := http.NewRequest("GET", ServiceUrl+ListTresorMembersPath, nil)
+	resp, err := c.SignAndDo(r)
+	if err != nil {
+		t.Fatalf("sign and do must not fail, was = %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestRotationBothKeysRejected(t *testing.T) {
+	tenantKey := "ffffffff"
+	var bodies []string
+	var events []KeyRotationEvent
+
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithSecondaryAdminKey(RotatedAdminKey),
+		WithKeyRotationHandler(func(e KeyRotationEvent) {
+			events = append(events, e)
+		}),
+	)
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = tenantAccepting(&tenantKey, &bodies)
+
+	r, _ := http.NewRequest("POST", ServiceUrl+ApproveTresorCreationPath,
+		bytes.NewBufferString(`{"TresorId":"xyz"}`))
+	resp, err := c.SignAndDo(r)
+	if err != nil {
+		t.Fatalf("sign and do must not fail, was = %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if len(events) != 0 {
+		t.Errorf("number of rotation events = %d, want = %d", len(events), 0)
+	}
+	primary, _ := c.signingKeys()
+	if key, _ := primary.AdminKey(); hex.EncodeToString(key) != AdminKey {
+		t.Errorf("primary key must not change when both keys are rejected")
+	}
+}
+
+func TestNewClientRejectsInvalidSecondaryKey(t *testing.T) {
+	_, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithSecondaryAdminKey("0g"))
+	if err != ErrInvalidAdminKey {
+		t.Errorf("err = %v, want = %v", err, ErrInvalidAdminKey)
+	}
+}
@@ -36,9 +36,11 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -49,8 +51,8 @@ import (
 //
 // 1. Assemble the request headers:
 //
-//     - Content-Type: application/json (Post only)
-//     - Content-SHA256: <sha256hex of the request body> (Post only)
+//     - Content-Type: application/json (requests with a body only)
+//     - Content-SHA256: <sha256hex of the request body> (requests with a body only)
 //     - TresoritDate: <timestamp in ISO-8601>
 //     - UserId: <tenant admin user id>
 //     - HMACHeaders: <comma separated headers>
@@ -81,28 +83,57 @@ import (
 // where SignatureBase64 is the previously computed signature of the canonical
 // request string.
 type requestSigner struct {
-	adminKey    string
+	mu          sync.RWMutex
+	keys        KeyProvider
+	secondary   KeyProvider
 	adminUserId string
+	onRotate    func(KeyRotationEvent)
+	wiped       bool
+}
+
+// signingKeys returns the primary and the (optional) secondary admin key.
+func (s *requestSigner) signingKeys() (primary, secondary KeyProvider) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys, s.secondary
+}
+
+// wipe zeroes the admin keys kept in memory by the key providers and
+// prevents the signer from signing any further requests.
+func (s *requestSigner) wipe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, keys := range []KeyProvider{s.keys, s.secondary} {
+		if w, ok := keys.(Wiper); ok {
+			w.Wipe()
+		}
+	}
+	s.wiped = true
 }
 
 func (s *requestSigner) sign(req *http.Request) error {
-	if req.Method == "POST" {
+	primary, _ := s.signingKeys()
+	return s.signWith(req, primary)
+}
+
+func (s *requestSigner) signWith(req *http.Request, keys KeyProvider) error {
+	s.mu.RLock()
+	wiped := s.wiped
+	s.mu.RUnlock()
+	if wiped {
+		return ErrAdminKeyWiped
+	}
+
+	if hasBody(req) {
 		req.Header["Content-Type"] = []string{"application/json"}
 
-		body := req.Body
-		var (
-			bodyBytes []byte
-			err       error
-		)
-		if body != nil {
-			bodyBytes, err = ioutil.ReadAll(body)
+		if _, ok := req.Header["Content-SHA256"]; !ok {
+			sum, err := bodySHA256(req)
 			if err != nil {
 				return err
 			}
-			// Restore the io.ReadCloser to its original state
-			req.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+			req.Header["Content-SHA256"] = []string{sum}
 		}
-		req.Header["Content-SHA256"] = []string{sha256hex(bodyBytes)}
 	}
 
 	// The Add and Set methods of http.Header canonicalize header names when
@@ -136,7 +167,10 @@ func (s *requestSigner) sign(req *http.Request) error {
 	}
 
 	// sign the canonicalized string of the requests
-	sig, err := computeHmacSHA256([]byte(buffer.String()), s.adminKey)
+	var sig []byte
+	err := useAdminKey(keys, func(key []byte) {
+		sig = computeHmacSHA256(buffer.Bytes(), key)
+	})
 	if err != nil {
 		return err
 	}
@@ -147,19 +181,82 @@ func (s *requestSigner) sign(req *http.Request) error {
 	return nil
 }
 
+// hasBody reports whether the request carries a body, which has to be
+// covered by the signature. POST, PUT and PATCH requests always do, even if
+// the body is empty; other requests only if a body is set.
+func hasBody(req *http.Request) bool {
+	switch req.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+// SetContentSHA256 sets the hex encoded SHA256 of the request body, which
+// has been computed in advance, e.g. while the body was written to disk. The
+// body is not read to sign the request then.
+func SetContentSHA256(req *http.Request, sha256hex string) {
+	req.Header["Content-SHA256"] = []string{sha256hex}
+}
+
+// bodySHA256 computes the hex encoded SHA256 of the request body without
+// holding the whole body in memory whenever possible: the body is hashed
+// from a copy obtained by req.GetBody, or, if it is an io.Seeker, in place
+// before rewinding it. Other bodies are buffered, so that they can be sent
+// after being hashed.
+func bodySHA256(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return sha256hex(nil), nil
+	}
+
+	h := sha256.New()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	if seeker, ok := req.Body.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, req.Body); err != nil {
+			return "", err
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	bodyBytes, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body.Close()
+	// Restore the io.ReadCloser to its original state and keep the content
+	// around, so the request can be signed and sent again.
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(bodyBytes)), nil
+	}
+	return sha256hex(bodyBytes), nil
+}
+
 // The function to compute the keyed-hash message authentication code (HMAC)
 // of the input data, keyed with the binary input key and using the SHA256
 // algorithm as hash function.
-func computeHmacSHA256(data []byte, secret string) ([]byte, error) {
-	key := []byte(secret)
-	dst := make([]byte, hex.DecodedLen(len(key)))
-	_, err := hex.Decode(dst, key)
-	if err != nil {
-		return nil, err
-	}
-	h := hmac.New(sha256.New, dst)
+func computeHmacSHA256(data []byte, key []byte) []byte {
+	h := hmac.New(sha256.New, key)
 	h.Write(data)
-	return h.Sum(nil), nil
+	return h.Sum(nil)
 }
 
 func sha256hex(content []byte) string {
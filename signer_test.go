@@ -33,6 +33,9 @@ package zerokit
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
@@ -58,13 +61,11 @@ var testDataHmac256 = []hmac256{
 
 func TestComputeHmac256(t *testing.T) {
 	for _, test := range testDataHmac256 {
-		r, err := computeHmacSHA256(test.data, test.secret)
+		key, err := decodeAdminKey(test.secret)
 		if err != nil {
-			t.Errorf(
-				"cannot compute hmac256 for data: %q and secret: %s",
-				test.data, test.secret,
-			)
+			t.Errorf("cannot decode secret: %s", test.secret)
 		}
+		r := computeHmacSHA256(test.data, key)
 		hmac256hex := base64.StdEncoding.EncodeToString(r)
 		if hmac256hex != test.hmac {
 			t.Errorf(
@@ -75,16 +76,41 @@ func TestComputeHmac256(t *testing.T) {
 	}
 }
 
-func TestComputeHmac256InvalidSecret(t *testing.T) {
-	_, err := computeHmacSHA256(nil, "0g")
-	if err == nil {
-		t.Errorf(
-			"expected invalid byte error for data: %q and secret: %s",
-			nil, "0g",
-		)
+func TestDecodeAdminKeyInvalidSecret(t *testing.T) {
+	for _, secret := range []string{"", "0g", "abc"} {
+		_, err := decodeAdminKey(secret)
+		if err != ErrInvalidAdminKey {
+			t.Errorf(
+				"decodeAdminKey(%q) err = %v, want %v",
+				secret, err, ErrInvalidAdminKey,
+			)
+		}
+	}
+}
+
+func TestDecodeAdminKeyBytesWipesInput(t *testing.T) {
+	input := []byte(AdminKey + "\n")
+	key, err := decodeAdminKeyBytes(input)
+	if err != nil {
+		t.Fatalf("cannot decode secret: %v", err)
+	}
+	if hex.EncodeToString(key) != AdminKey {
+		t.Errorf("key = %x, want %s", key, AdminKey)
+	}
+	if !bytes.Equal(input, make([]byte, len(input))) {
+		t.Errorf("hex encoded input is not wiped: %q", input)
 	}
 }
 
+// testKey returns the static KeyProvider for the given hex encoded key.
+func testKey(adminKey string) KeyProvider {
+	keys, err := newStaticKey(adminKey)
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
 type bytesHex struct {
 	data []byte
 	hex  string
@@ -106,7 +132,7 @@ func TestSha256hex(t *testing.T) {
 }
 
 func TestPostRequestSigningWithContent(t *testing.T) {
-	s := requestSigner{adminUserId: AdminUserId, adminKey: AdminKey}
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
 
 	content := []byte("{\"TresorId\":\"e32ve3ve\"}")
 	r, _ := http.NewRequest("POST", "", bytes.NewBuffer(content))
@@ -150,7 +176,7 @@ func TestPostRequestSigningWithContent(t *testing.T) {
 }
 
 func TestPostRequestSigningWithoutContent(t *testing.T) {
-	s := requestSigner{adminUserId: AdminUserId, adminKey: AdminKey}
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
 
 	r, _ := http.NewRequest("POST", "", nil)
 	err := s.sign(r)
@@ -199,7 +225,7 @@ func header(r *http.Request, key string) string {
 }
 
 func TestGetRequestSigning(t *testing.T) {
-	s := requestSigner{adminUserId: AdminUserId, adminKey: AdminKey}
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
 
 	r, _ := http.NewRequest("GET", "", nil)
 	err := s.sign(r)
@@ -236,7 +262,7 @@ func TestGetRequestSigning(t *testing.T) {
 }
 
 func TestGetRequestSigningWithQueryParameters(t *testing.T) {
-	s := requestSigner{adminUserId: AdminUserId, adminKey: AdminKey}
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
 
 	r, _ := http.NewRequest("GET", "", nil)
 	q := r.URL.Query()
@@ -274,10 +300,184 @@ func TestGetRequestSigningWithQueryParameters(t *testing.T) {
 		t.Errorf("auth credentials are not in base64 encoding: %s", auth[1])
 	}
 
-	s.adminKey = "0g"
+	s.wipe()
 	r, _ = http.NewRequest("GET", "", nil)
 	err = s.sign(r)
-	if err == nil {
-		t.Error("expected invalid byte error")
+	if err != ErrAdminKeyWiped {
+		t.Errorf("err = %v, want %v", err, ErrAdminKeyWiped)
+	}
+}
+
+// seekableBody is a request body which can be hashed in place.
+type seekableBody struct {
+	*bytes.Reader
+}
+
+func (b *seekableBody) Close() error {
+	return nil
+}
+
+// failingBody is a request body which must not be read.
+type failingBody struct{}
+
+func (failingBody) Read(p []byte) (int, error) {
+	return 0, errors.New("body must not be read")
+}
+
+func (failingBody) Close() error {
+	return nil
+}
+
+func TestPostRequestSigningWithGetBody(t *testing.T) {
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
+
+	content := []byte("{\"TresorId\":\"e32ve3ve\"}")
+	r, _ := http.NewRequest("POST", "", bytes.NewReader(content))
+	body := r.Body
+	err := s.sign(r)
+	if err != nil {
+		t.Errorf("cannot sign request: %v", err)
+	}
+
+	if r.Body != body {
+		t.Error("request body must not be replaced when GetBody is set")
+	}
+	if header(r, "Content-SHA256") != sha256hex(content) {
+		t.Errorf(
+			"Content-SHA256 = %s; want %s",
+			header(r, "Content-SHA256"), sha256hex(content),
+		)
+	}
+	sent, _ := ioutil.ReadAll(r.Body)
+	if !bytes.Equal(sent, content) {
+		t.Errorf("body = %q; want %q", sent, content)
+	}
+}
+
+func TestPostRequestSigningWithSeekableBody(t *testing.T) {
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
+
+	content := []byte("{\"TresorId\":\"e32ve3ve\"}")
+	body := &seekableBody{Reader: bytes.NewReader(content)}
+	r, _ := http.NewRequest("POST", "", nil)
+	r.Body = body
+	err := s.sign(r)
+	if err != nil {
+		t.Errorf("cannot sign request: %v", err)
+	}
+
+	if r.Body != body {
+		t.Error("seekable request body must be hashed in place")
+	}
+	if header(r, "Content-SHA256") != sha256hex(content) {
+		t.Errorf(
+			"Content-SHA256 = %s; want %s",
+			header(r, "Content-SHA256"), sha256hex(content),
+		)
+	}
+	sent, _ := ioutil.ReadAll(r.Body)
+	if !bytes.Equal(sent, content) {
+		t.Errorf("body = %q; want %q", sent, content)
+	}
+}
+
+func TestPostRequestSigningWithPrecomputedHash(t *testing.T) {
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
+
+	r, _ := http.NewRequest("POST", "", nil)
+	r.Body = failingBody{}
+	SetContentSHA256(r, sha256hex([]byte("precomputed")))
+	err := s.sign(r)
+	if err != nil {
+		t.Errorf("cannot sign request: %v", err)
+	}
+
+	if header(r, "Content-SHA256") != sha256hex([]byte("precomputed")) {
+		t.Errorf(
+			"Content-SHA256 = %s; want %s",
+			header(r, "Content-SHA256"), sha256hex([]byte("precomputed")),
+		)
+	}
+	if !strings.Contains(header(r, "HMACHeaders"), "Content-SHA256") {
+		t.Errorf("HMACHeaders = %s; must contain Content-SHA256",
+			header(r, "HMACHeaders"))
+	}
+}
+
+func TestPostRequestSigningWithUnknownBody(t *testing.T) {
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
+
+	content := []byte("{\"TresorId\":\"e32ve3ve\"}")
+	r, _ := http.NewRequest("POST", "", nil)
+	r.Body = ioutil.NopCloser(strings.NewReader(string(content)))
+	err := s.sign(r)
+	if err != nil {
+		t.Errorf("cannot sign request: %v", err)
+	}
+
+	if header(r, "Content-SHA256") != sha256hex(content) {
+		t.Errorf(
+			"Content-SHA256 = %s; want %s",
+			header(r, "Content-SHA256"), sha256hex(content),
+		)
+	}
+	if r.GetBody == nil {
+		t.Error("buffered request body must be replayable")
+	}
+	sent, _ := ioutil.ReadAll(r.Body)
+	if !bytes.Equal(sent, content) {
+		t.Errorf("body = %q; want %q", sent, content)
+	}
+}
+
+type methodSigning struct {
+	method        string
+	content       []byte
+	contentType   string
+	contentSha256 string
+}
+
+var testDataMethodSigning = []methodSigning{
+	{"PUT", []byte("{\"TresorId\":\"e32ve3ve\"}"), "application/json",
+		sha256hex([]byte("{\"TresorId\":\"e32ve3ve\"}"))},
+	{"PUT", nil, "application/json", Sha256HexEmpty},
+	{"PATCH", []byte("{\"UserId\":\"zk\"}"), "application/json",
+		sha256hex([]byte("{\"UserId\":\"zk\"}"))},
+	{"PATCH", nil, "application/json", Sha256HexEmpty},
+	{"DELETE", []byte("{\"UserId\":\"zk\"}"), "application/json",
+		sha256hex([]byte("{\"UserId\":\"zk\"}"))},
+	{"DELETE", nil, "", ""},
+}
+
+func TestRequestSigningMethods(t *testing.T) {
+	s := requestSigner{adminUserId: AdminUserId, keys: testKey(AdminKey)}
+
+	for _, test := range testDataMethodSigning {
+		var body *bytes.Buffer
+		r, _ := http.NewRequest(test.method, "", nil)
+		if test.content != nil {
+			body = bytes.NewBuffer(test.content)
+			r, _ = http.NewRequest(test.method, "", body)
+		}
+		err := s.sign(r)
+		if err != nil {
+			t.Errorf("cannot sign %s request: %v", test.method, err)
+		}
+
+		if header(r, "Content-Type") != test.contentType {
+			t.Errorf(
+				"%s Content-Type = %s; want %s",
+				test.method, header(r, "Content-Type"), test.contentType,
+			)
+		}
+		if header(r, "Content-SHA256") != test.contentSha256 {
+			t.Errorf(
+				"%s Content-SHA256 = %s; want %s",
+				test.method, header(r, "Content-SHA256"), test.contentSha256,
+			)
+		}
+		if !verifySignature(r, AdminKey) {
+			t.Errorf("%s request signature is not valid", test.method)
+		}
 	}
 }
@@ -0,0 +1,82 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates a span for every admin API operation performed by the
+// client. The operation is named after the client method, e.g.
+// ValidateUserRegistration, and the endpoint is the path of the admin API
+// called by it. Implementations for a concrete tracing system, like the one
+// in the zerokitotel package, adapt it to this interface.
+type Tracer interface {
+	// Start begins a span for the given operation as a child of the span
+	// carried by ctx and returns a context carrying the new span.
+	Start(ctx context.Context, operation, endpoint string) (context.Context, Span)
+
+	// Inject writes the trace context carried by ctx into the headers of an
+	// outgoing request.
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a single traced admin API operation.
+type Span interface {
+	// End finishes the span with the HTTP status code of the response, or
+	// with the error which prevented the request from being completed. The
+	// status code is zero if no response was received.
+	End(statusCode int, err error)
+}
+
+// WithTracer sets the tracer used to create spans for the admin API calls.
+// A nil tracer disables tracing.
+func WithTracer(t Tracer) Option {
+	return func(c *ZeroKitAdminApiClient) {
+		if t == nil {
+			t = noopTracer{}
+		}
+		c.tracer = t
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(context.Context, http.Header) {}
+
+type noopSpan struct{}
+
+func (noopSpan) End(int, error) {}
@@ -0,0 +1,176 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type recordedSpan struct {
+	operation  string
+	endpoint   string
+	statusCode int
+	err        error
+	ended      bool
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, operation,
+	endpoint string) (context.Context, Span) {
+	s := &recordedSpan{operation: operation, endpoint: endpoint}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, operation), s
+}
+
+func (t *recordingTracer) Inject(ctx context.Context, header http.Header) {
+	if op, ok := ctx.Value(spanKey{}).(string); ok {
+		header["Traceparent"] = []string{op}
+	}
+}
+
+func (s *recordedSpan) End(statusCode int, err error) {
+	s.statusCode = statusCode
+	s.err = err
+	s.ended = true
+}
+
+func TestTracerSpanPerOperation(t *testing.T) {
+	tracer := &recordingTracer{}
+	client := &mockHttpClient{
+		DoMock: func(req *http.Request) (*http.Response, error) {
+			if req.Header["Traceparent"] == nil {
+				t.Error("trace context is not propagated")
+			}
+			if strings.Contains(header(req, "HMACHeaders"), "Traceparent") {
+				t.Errorf(
+					"HMACHeaders = %s, must not contain Traceparent",
+					header(req, "HMACHeaders"),
+				)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			}, nil
+		},
+	}
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithTracer(tracer))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = client
+
+	c.ListTresorMembers("xyz")
+	c.InitUserRegistration()
+	c.ApproveTresorCreation("xyz")
+	c.ValidateUserRegistration("zk", "session", "verifier", "validation")
+
+	expected := []recordedSpan{
+		{operation: "ListTresorMembers", endpoint: ListTresorMembersPath},
+		{operation: "InitUserRegistration", endpoint: InitiateUserRegistrationPath},
+		{operation: "ApproveTresorCreation", endpoint: ApproveTresorCreationPath},
+		{operation: "ValidateUserRegistration", endpoint: ValidateUserRegistrationPath},
+	}
+	if len(tracer.spans) != len(expected) {
+		t.Fatalf("number of spans = %d, want = %d",
+			len(tracer.spans), len(expected))
+	}
+	for i, s := range tracer.spans {
+		if s.operation != expected[i].operation {
+			t.Errorf("operation = %s, want = %s",
+				s.operation, expected[i].operation)
+		}
+		if s.endpoint != expected[i].endpoint {
+			t.Errorf("endpoint = %s, want = %s", s.endpoint, expected[i].endpoint)
+		}
+		if !s.ended || s.statusCode != http.StatusOK || s.err != nil {
+			t.Errorf(
+				"span %s ended = %v with status = %d and err = %v, want = %d",
+				s.operation, s.ended, s.statusCode, s.err, http.StatusOK,
+			)
+		}
+	}
+}
+
+func TestTracerSpanEndsWithTransportError(t *testing.T) {
+	tracer := &recordingTracer{}
+	failure := errors.New("connection reset")
+	client := &mockHttpClient{
+		DoMock: func(req *http.Request) (*http.Response, error) {
+			return nil, failure
+		},
+	}
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithTracer(tracer))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = client
+
+	err = c.ApproveTresorCreationContext(context.Background(), "xyz")
+	if err != failure {
+		t.Errorf("err = %v, want = %v", err, failure)
+	}
+	if len(tracer.spans) != 1 || tracer.spans[0].err != failure {
+		t.Errorf("span must end with the transport error, was %+v", tracer.spans)
+	}
+}
+
+func TestNilTracerDisablesTracing(t *testing.T) {
+	c, err := NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		WithTracer(nil))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+	c.httpClient = &mockHttpClient{
+		DoMock: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		},
+	}
+
+	if err := c.ApproveTresorCreation("xyz"); err != nil {
+		t.Errorf("err = %v, want = nil", err)
+	}
+}
@@ -0,0 +1,100 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Recorder sends the requests with the wrapped Doer and writes every
+// exchange to the cassette.
+type Recorder struct {
+	next  Doer
+	scrub *scrubber
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder creates a Recorder which writes the cassette to w.
+func NewRecorder(next Doer, w io.Writer, opts ...Option) *Recorder {
+	return &Recorder{
+		next:  next,
+		scrub: newScrubber(opts),
+		enc:   json.NewEncoder(w),
+	}
+}
+
+// Do sends the request to the wrapped client and records the exchange.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	interaction := Interaction{
+		Request: Request{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Query:  req.URL.Query().Encode(),
+			Header: r.scrub.header(req.Header),
+			Body:   r.scrub.body(reqBody),
+		},
+	}
+
+	resp, err := r.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	interaction.Response = Response{
+		StatusCode: resp.StatusCode,
+		Header:     r.scrub.header(resp.Header),
+		Body:       r.scrub.body(respBody),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(interaction); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,145 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package vcr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ErrNoInteraction is returned for requests not found in the cassette.
+var ErrNoInteraction = errors.New("no recorded interaction matches the request")
+
+// Replayer responds to the requests with the recorded responses. A request
+// matches an interaction if the method, path, query and scrubbed body are
+// equal; the headers are ignored. Every interaction is replayed once, in the
+// order in which it was recorded.
+type Replayer struct {
+	scrub *scrubber
+
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     []bool
+}
+
+// NewReplayer reads the cassette from r.
+func NewReplayer(r io.Reader, opts ...Option) (*Replayer, error) {
+	var interactions []Interaction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var i Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("cassette line %d: %v", line, err)
+		}
+		interactions = append(interactions, i)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &Replayer{
+		scrub:        newScrubber(opts),
+		interactions: interactions,
+		replayed:     make([]bool, len(interactions)),
+	}, nil
+}
+
+// LoadCassette creates a Replayer from the cassette file at path.
+func LoadCassette(path string, opts ...Option) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplayer(f, opts...)
+}
+
+// Do returns the recorded response to the request.
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	query := req.URL.Query().Encode()
+	scrubbed := r.scrub.body(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		recorded := interaction.Request
+		if r.replayed[i] || recorded.Method != req.Method ||
+			recorded.Path != req.URL.Path || recorded.Query != query ||
+			!equalBodies(recorded.Body, scrubbed) {
+			continue
+		}
+		r.replayed[i] = true
+		return newResponse(req, interaction.Response), nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL.Path)
+}
+
+// Remaining returns the number of recorded interactions not replayed yet.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, replayed := range r.replayed {
+		if !replayed {
+			n++
+		}
+	}
+	return n
+}
+
+// equalBodies compares JSON bodies independent of their formatting and key
+// order, and other bodies byte by byte.
+func equalBodies(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
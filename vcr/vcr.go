@@ -0,0 +1,199 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package vcr records the exchanges of a ZeroKit admin API client with a
+// tenant to a cassette and replays them later, so that tests run against
+// real responses without network access.
+//
+// A cassette is a JSONL file with one Interaction per line. Secrets, i.e. the
+// Authorization header and the registration verifiers, are scrubbed before
+// an interaction is written. The volatile TresoritDate and Authorization
+// headers are ignored when the recorded requests are matched during replay.
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// Redacted replaces the scrubbed secrets in the cassette.
+const Redacted = "REDACTED"
+
+var (
+	// DefaultScrubbedHeaders are the headers redacted by default.
+	DefaultScrubbedHeaders = []string{"Authorization"}
+	// DefaultScrubbedFields are the JSON body fields redacted by default.
+	DefaultScrubbedFields = []string{"RegSessionVerifier", "RegValidationVerifier"}
+)
+
+// Interaction is a recorded request/response pair.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the recorded part of a request.
+type Request struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is the recorded part of a response.
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Doer sends HTTP requests. It is implemented by *http.Client and by the
+// Recorder and Replayer of this package.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Option configures a Recorder or Replayer.
+type Option func(*scrubber)
+
+// WithScrubbedHeaders sets the headers which are redacted in the cassette.
+func WithScrubbedHeaders(headers ...string) Option {
+	return func(s *scrubber) {
+		s.headers = headers
+	}
+}
+
+// WithScrubbedFields sets the JSON body fields which are redacted in the
+// cassette.
+func WithScrubbedFields(fields ...string) Option {
+	return func(s *scrubber) {
+		s.fields = fields
+	}
+}
+
+type scrubber struct {
+	headers []string
+	fields  []string
+}
+
+func newScrubber(opts []Option) *scrubber {
+	s := &scrubber{
+		headers: DefaultScrubbedHeaders,
+		fields:  DefaultScrubbedFields,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *scrubber) header(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	for _, name := range s.headers {
+		// the signed headers are not canonicalized, so both forms are checked
+		for _, k := range []string{name, http.CanonicalHeaderKey(name)} {
+			if _, ok := c[k]; ok {
+				c[k] = []string{Redacted}
+			}
+		}
+	}
+	return c
+}
+
+// body redacts the scrubbed fields of a JSON object body. Other bodies are
+// returned as they are.
+func (s *scrubber) body(body []byte) string {
+	var m map[string]json.RawMessage
+	if len(s.fields) == 0 || json.Unmarshal(body, &m) != nil {
+		return string(body)
+	}
+	redacted, _ := json.Marshal(Redacted)
+	changed := false
+	for _, field := range s.fields {
+		if _, ok := m[field]; ok {
+			m[field] = redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return string(body)
+	}
+	scrubbed, err := json.Marshal(m)
+	if err != nil {
+		return string(body)
+	}
+	return string(scrubbed)
+}
+
+// readRequestBody returns the request body without consuming it for the
+// following Do.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+	content, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(content))
+	return content, nil
+}
+
+func newResponse(req *http.Request, r Response) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(r.StatusCode),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,172 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package vcr
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+const (
+	ServiceUrl  = "https://exampletenant.tresorit.io"
+	AdminUserId = "admin@exampletenant.tresorit.io"
+	AdminKey    = "204bcf1b"
+)
+
+type tenantFunc func(req *http.Request) (*http.Response, error)
+
+func (f tenantFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// stagingTenant stands in for the real tenant while recording.
+var stagingTenant = tenantFunc(func(req *http.Request) (*http.Response, error) {
+	body := ""
+	switch req.URL.Path {
+	case zerokit.ListTresorMembersPath:
+		body = `{"Members":["zk1","zk2"]}`
+	case zerokit.InitiateUserRegistrationPath:
+		body = `{"RegSessionId":"session","RegSessionVerifier":"s3cr3t","UserId":"zk"}`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+	}, nil
+})
+
+func record(t *testing.T) []byte {
+	var cassette bytes.Buffer
+	c, err := zerokit.NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		zerokit.WithHttpClient(NewRecorder(stagingTenant, &cassette)))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+
+	if _, err := c.ListTresorMembers("xyz"); err != nil {
+		t.Fatalf("recording list tresor members failed: %v", err)
+	}
+	if _, err := c.InitUserRegistration(); err != nil {
+		t.Fatalf("recording init user registration failed: %v", err)
+	}
+	err = c.ValidateUserRegistration("zk", "session", "s3cr3t", "v4lid4tion")
+	if err != nil {
+		t.Fatalf("recording validate user registration failed: %v", err)
+	}
+	return cassette.Bytes()
+}
+
+func TestRecordScrubsSecrets(t *testing.T) {
+	cassette := record(t)
+
+	if n := bytes.Count(cassette, []byte("\n")); n != 3 {
+		t.Errorf("number of interactions = %d, want = %d", n, 3)
+	}
+	for _, secret := range []string{"s3cr3t", "v4lid4tion", "AdminKey "} {
+		if bytes.Contains(cassette, []byte(secret)) {
+			t.Errorf("cassette contains secret %q:\n%s", secret, cassette)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	replayer, err := NewReplayer(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatalf("cannot read cassette: %v", err)
+	}
+	c, err := zerokit.NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		zerokit.WithHttpClient(replayer))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+
+	members, err := c.ListTresorMembers("xyz")
+	if err != nil {
+		t.Fatalf("replaying list tresor members failed: %v", err)
+	}
+	if strings.Join(members, ",") != "zk1,zk2" {
+		t.Errorf("members = %v, want = %v", members, []string{"zk1", "zk2"})
+	}
+
+	reg, err := c.InitUserRegistration()
+	if err != nil {
+		t.Fatalf("replaying init user registration failed: %v", err)
+	}
+	if reg.UserId != "zk" || reg.SessionVerifier != Redacted {
+		t.Errorf("unexpected registration data %+v", reg)
+	}
+
+	// the verifiers are scrubbed from the request before matching, so the
+	// test does not need to know the recorded ones
+	err = c.ValidateUserRegistration("zk", "session", "other", "other")
+	if err != nil {
+		t.Fatalf("replaying validate user registration failed: %v", err)
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("remaining interactions = %d, want = %d", replayer.Remaining(), 0)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	replayer, err := NewReplayer(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatalf("cannot read cassette: %v", err)
+	}
+	c, err := zerokit.NewZeroKitAdminApiClient(ServiceUrl, AdminUserId, AdminKey,
+		zerokit.WithHttpClient(replayer))
+	if err != nil {
+		t.Fatal("cannot initialize tresorit client")
+	}
+
+	_, err = c.ListTresorMembers("other")
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("err = %v, want = %v", err, ErrNoInteraction)
+	}
+
+	c.ListTresorMembers("xyz")
+	_, err = c.ListTresorMembers("xyz")
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("replayed interaction must not match again, err = %v", err)
+	}
+}
+
+func TestReplayInvalidCassette(t *testing.T) {
+	_, err := NewReplayer(strings.NewReader("{\"request\":\n"))
+	if err == nil {
+		t.Error("expected error for malformed cassette")
+	}
+}
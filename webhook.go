@@ -0,0 +1,368 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EventType is the type of a tenant event.
+type EventType string
+
+// The tenant events with a typed representation.
+const (
+	EventUserRegistered EventType = "UserRegistered"
+	EventTresorCreated  EventType = "TresorCreated"
+	EventMemberShared   EventType = "MemberShared"
+)
+
+// DefaultMaxClockSkew is the default maximum difference between the time an
+// event callback has been signed and the time it is received.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// maxEventSize is the maximum size of the body of an event callback.
+const maxEventSize = 1 << 20
+
+// EventMeta are the fields common to all events.
+type EventMeta struct {
+	EventId string
+	Time    time.Time
+}
+
+// Event is a typed tenant event.
+type Event interface {
+	// EventType returns the type of the event.
+	EventType() EventType
+	meta() *EventMeta
+}
+
+// UserRegisteredEvent is sent once a user has completed the registration.
+type UserRegisteredEvent struct {
+	EventMeta `json:"-"`
+	UserId    string `json:"UserId"`
+}
+
+// EventType returns EventUserRegistered.
+func (e *UserRegisteredEvent) EventType() EventType { return EventUserRegistered }
+
+func (e *UserRegisteredEvent) meta() *EventMeta { return &e.EventMeta }
+
+// TresorCreatedEvent is sent once a user has created a tresor.
+type TresorCreatedEvent struct {
+	EventMeta `json:"-"`
+	TresorId  string `json:"TresorId"`
+	// UserId is the id of the user who has created the tresor.
+	UserId string `json:"UserId"`
+}
+
+// EventType returns EventTresorCreated.
+func (e *TresorCreatedEvent) EventType() EventType { return EventTresorCreated }
+
+func (e *TresorCreatedEvent) meta() *EventMeta { return &e.EventMeta }
+
+// MemberSharedEvent is sent once a tresor has been shared with a user.
+type MemberSharedEvent struct {
+	EventMeta   `json:"-"`
+	TresorId    string `json:"TresorId"`
+	UserId      string `json:"UserId"`
+	SharedBy    string `json:"SharedBy"`
+	OperationId string `json:"OperationId"`
+}
+
+// EventType returns EventMemberShared.
+func (e *MemberSharedEvent) EventType() EventType { return EventMemberShared }
+
+func (e *MemberSharedEvent) meta() *EventMeta { return &e.EventMeta }
+
+// RawEvent is an event without a typed representation.
+type RawEvent struct {
+	EventMeta
+	Type EventType
+	Data json.RawMessage
+}
+
+// EventType returns the type of the event.
+func (e *RawEvent) EventType() EventType { return e.Type }
+
+func (e *RawEvent) meta() *EventMeta { return &e.EventMeta }
+
+// eventEnvelope is the body of an event callback.
+type eventEnvelope struct {
+	EventId   string          `json:"EventId"`
+	EventType EventType       `json:"EventType"`
+	Time      time.Time       `json:"Time"`
+	Data      json.RawMessage `json:"Data"`
+}
+
+// WebhookHandler is an http.Handler receiving the event callbacks of a
+// tenant. The callbacks are signed with the admin key like the requests to
+// the admin API; callbacks with an invalid signature, a body not matching
+// its Content-SHA256 or a TresoritDate too far off are rejected.
+//
+// Handler funcs must be registered before the WebhookHandler serves
+// requests. If a handler func fails, the callback is answered with 500, so
+// that it is sent again; handler funcs should therefore be idempotent.
+// Events without a handler func are acknowledged and dropped.
+type WebhookHandler struct {
+	keys         []KeyProvider
+	maxClockSkew time.Duration
+	now          func() time.Time
+
+	handlers map[EventType]func(context.Context, EventMeta, json.RawMessage) error
+	fallback func(context.Context, *RawEvent) error
+	onError  func(*http.Request, error)
+}
+
+// WebhookOption configures optional behaviour of the WebhookHandler.
+type WebhookOption func(*WebhookHandler)
+
+// WithWebhookSecondaryKey accepts callbacks signed with the secondary admin
+// key as well, e.g. while the admin key is rotated.
+func WithWebhookSecondaryKey(keys KeyProvider) WebhookOption {
+	return func(h *WebhookHandler) {
+		h.keys = append(h.keys, keys)
+	}
+}
+
+// WithMaxClockSkew sets the maximum difference between the time a callback
+// has been signed and the time it is received. It defaults to
+// DefaultMaxClockSkew and limits the window for replaying callbacks.
+func WithMaxClockSkew(d time.Duration) WebhookOption {
+	return func(h *WebhookHandler) {
+		h.maxClockSkew = d
+	}
+}
+
+// WithWebhookErrorHandler sets the func the reasons for rejected callbacks
+// and the errors of failed handler funcs are passed to, e.g. to log them.
+// They are not sent to the caller, which is not authenticated yet.
+func WithWebhookErrorHandler(fn func(r *http.Request, err error)) WebhookOption {
+	return func(h *WebhookHandler) {
+		h.onError = fn
+	}
+}
+
+// NewWebhookHandler creates a handler verifying the callbacks with the admin
+// key supplied by keys.
+func NewWebhookHandler(keys KeyProvider, opts ...WebhookOption) *WebhookHandler {
+	h := &WebhookHandler{
+		keys:         []KeyProvider{keys},
+		maxClockSkew: DefaultMaxClockSkew,
+		now:          time.Now,
+		handlers:     map[EventType]func(context.Context, EventMeta, json.RawMessage) error{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// OnUserRegistered registers the handler func of EventUserRegistered.
+func (h *WebhookHandler) OnUserRegistered(fn func(context.Context, *UserRegisteredEvent) error) {
+	h.handlers[EventUserRegistered] = func(ctx context.Context, meta EventMeta,
+		data json.RawMessage) error {
+		event := &UserRegisteredEvent{EventMeta: meta}
+		if err := decodeEventData(data, event); err != nil {
+			return err
+		}
+		return fn(ctx, event)
+	}
+}
+
+// OnTresorCreated registers the handler func of EventTresorCreated.
+func (h *WebhookHandler) OnTresorCreated(fn func(context.Context, *TresorCreatedEvent) error) {
+	h.handlers[EventTresorCreated] = func(ctx context.Context, meta EventMeta,
+		data json.RawMessage) error {
+		event := &TresorCreatedEvent{EventMeta: meta}
+		if err := decodeEventData(data, event); err != nil {
+			return err
+		}
+		return fn(ctx, event)
+	}
+}
+
+// OnMemberShared registers the handler func of EventMemberShared.
+func (h *WebhookHandler) OnMemberShared(fn func(context.Context, *MemberSharedEvent) error) {
+	h.handlers[EventMemberShared] = func(ctx context.Context, meta EventMeta,
+		data json.RawMessage) error {
+		event := &MemberSharedEvent{EventMeta: meta}
+		if err := decodeEventData(data, event); err != nil {
+			return err
+		}
+		return fn(ctx, event)
+	}
+}
+
+// OnOtherEvent registers the handler func of the events without a typed
+// representation, or without a handler func of their own.
+func (h *WebhookHandler) OnOtherEvent(fn func(context.Context, *RawEvent) error) {
+	h.fallback = fn
+}
+
+// errInvalidEvent marks events whose data cannot be decoded, which are
+// answered with 400 instead of 500.
+type errInvalidEvent struct {
+	err error
+}
+
+func (e errInvalidEvent) Error() string {
+	return "invalid event: " + e.err.Error()
+}
+
+func decodeEventData(data json.RawMessage, event Event) error {
+	if err := json.Unmarshal(data, event); err != nil {
+		return errInvalidEvent{err}
+	}
+	return nil
+}
+
+// ServeHTTP verifies, decodes and dispatches an event callback.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := readLimited(r.Body, maxEventSize)
+	if err == ErrResponseTooLarge {
+		http.Error(w, "event too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "cannot read event", http.StatusBadRequest)
+		return
+	}
+	if err := h.verify(r, body); err != nil {
+		h.reportError(r, fmt.Errorf("unauthorized event: %w", err))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var envelope eventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.EventType == "" {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	meta := EventMeta{EventId: envelope.EventId, Time: envelope.Time}
+
+	if handler, ok := h.handlers[envelope.EventType]; ok {
+		err = handler(r.Context(), meta, envelope.Data)
+	} else if h.fallback != nil {
+		err = h.fallback(r.Context(), &RawEvent{
+			EventMeta: meta,
+			Type:      envelope.EventType,
+			Data:      envelope.Data,
+		})
+	}
+	var invalid errInvalidEvent
+	switch {
+	case errors.As(err, &invalid):
+		h.reportError(r, err)
+		http.Error(w, invalid.Error(), http.StatusBadRequest)
+	case err != nil:
+		h.reportError(r, err)
+		http.Error(w, "event handler failed", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *WebhookHandler) reportError(r *http.Request, err error) {
+	if h.onError != nil {
+		h.onError(r, err)
+	}
+}
+
+// verify checks the signature of the callback, which covers the headers
+// listed in HMACHeaders in their order, and that the signed headers include
+// the date and the SHA256 of the body.
+func (h *WebhookHandler) verify(r *http.Request, body []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(r.Header.Get("Authorization"), "AdminKey "))
+	if err != nil || len(sig) == 0 {
+		return errors.New("missing signature")
+	}
+
+	signed := strings.Split(r.Header.Get("HMACHeaders"), ",")
+	var buffer bytes.Buffer
+	buffer.WriteString(r.Method + "\n")
+	buffer.WriteString(strings.TrimPrefix(r.URL.Path, "/"))
+	if r.URL.RawQuery != "" {
+		buffer.WriteString("?" + r.URL.RawQuery)
+	}
+	covered := map[string]bool{}
+	for _, key := range signed {
+		buffer.WriteString("\n" + key + ":" + r.Header.Get(key))
+		covered[http.CanonicalHeaderKey(key)] = true
+	}
+	if !covered["Tresoritdate"] || !covered["Content-Sha256"] {
+		return errors.New("date and body are not signed")
+	}
+
+	valid := false
+	for _, keys := range h.keys {
+		var expected []byte
+		err := useAdminKey(keys, func(key []byte) {
+			expected = computeHmacSHA256(buffer.Bytes(), key)
+		})
+		if err != nil {
+			return err
+		}
+		if hmac.Equal(sig, expected) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return errors.New("invalid signature")
+	}
+
+	if r.Header.Get("Content-SHA256") != sha256hex(body) {
+		return errors.New("body does not match its signed hash")
+	}
+	date, err := time.Parse(time.RFC3339, r.Header.Get("TresoritDate"))
+	if err != nil {
+		return errors.New("invalid date")
+	}
+	if skew := h.now().Sub(date); skew > h.maxClockSkew || -skew > h.maxClockSkew {
+		return errors.New(fmt.Sprintf("date is off by %v", skew))
+	}
+	return nil
+}
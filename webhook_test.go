@@ -0,0 +1,238 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWebhookServer(t *testing.T, h *WebhookHandler) *httptest.Server {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebhookHandlerDispatchesEvents(t *testing.T) {
+	h := NewWebhookHandler(testKey(AdminKey))
+	var (
+		registered *UserRegisteredEvent
+		created    *TresorCreatedEvent
+		shared     *MemberSharedEvent
+	)
+	h.OnUserRegistered(func(ctx context.Context, e *UserRegisteredEvent) error {
+		registered = e
+		return nil
+	})
+	h.OnTresorCreated(func(ctx context.Context, e *TresorCreatedEvent) error {
+		created = e
+		return nil
+	})
+	h.OnMemberShared(func(ctx context.Context, e *MemberSharedEvent) error {
+		shared = e
+		return nil
+	})
+	srv := newWebhookServer(t, h)
+	emitter := NewWebhookEmitter(srv.URL+"/zerokit/events?tenant=test",
+		AdminUserId, testKey(AdminKey), nil)
+
+	ctx := context.Background()
+	if err := emitter.Emit(ctx, &UserRegisteredEvent{UserId: "zk1"}); err != nil {
+		t.Fatalf("emit must not fail, was = %v", err)
+	}
+	at := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := emitter.Emit(ctx, &TresorCreatedEvent{
+		EventMeta: EventMeta{EventId: "event2", Time: at},
+		TresorId:  "tresor1",
+		UserId:    "zk1",
+	}); err != nil {
+		t.Fatalf("emit must not fail, was = %v", err)
+	}
+	if err := emitter.Emit(ctx, &MemberSharedEvent{
+		TresorId: "tresor1", UserId: "zk2", SharedBy: "zk1", OperationId: "op1",
+	}); err != nil {
+		t.Fatalf("emit must not fail, was = %v", err)
+	}
+
+	if registered == nil || registered.UserId != "zk1" || registered.EventId == "" ||
+		registered.Time.IsZero() {
+		t.Errorf("unexpected user registered event: %+v", registered)
+	}
+	if created == nil || created.EventId != "event2" || !created.Time.Equal(at) ||
+		created.TresorId != "tresor1" || created.UserId != "zk1" {
+		t.Errorf("unexpected tresor created event: %+v", created)
+	}
+	want := MemberSharedEvent{EventMeta: shared.EventMeta,
+		TresorId: "tresor1", UserId: "zk2", SharedBy: "zk1", OperationId: "op1"}
+	if *shared != want {
+		t.Errorf("member shared event = %+v, want = %+v", *shared, want)
+	}
+}
+
+func TestWebhookHandlerOtherEvents(t *testing.T) {
+	h := NewWebhookHandler(testKey(AdminKey))
+	srv := newWebhookServer(t, h)
+	emitter := NewWebhookEmitter(srv.URL, AdminUserId, testKey(AdminKey), nil)
+	event := &RawEvent{Type: "TresorDeleted", Data: json.RawMessage(`{"TresorId":"tresor1"}`)}
+
+	if err := emitter.Emit(context.Background(), event); err != nil {
+		t.Errorf("events without handler must be acknowledged, was = %v", err)
+	}
+
+	var received *RawEvent
+	h.OnOtherEvent(func(ctx context.Context, e *RawEvent) error {
+		received = e
+		return nil
+	})
+	if err := emitter.Emit(context.Background(), event); err != nil {
+		t.Fatalf("emit must not fail, was = %v", err)
+	}
+	if received == nil || received.Type != "TresorDeleted" ||
+		string(received.Data) != `{"TresorId":"tresor1"}` {
+		t.Errorf("unexpected event: %+v", received)
+	}
+}
+
+func TestWebhookHandlerFailures(t *testing.T) {
+	h := NewWebhookHandler(testKey(AdminKey))
+	h.OnUserRegistered(func(ctx context.Context, e *UserRegisteredEvent) error {
+		return errors.New("database is down")
+	})
+	srv := newWebhookServer(t, h)
+	emitter := NewWebhookEmitter(srv.URL, AdminUserId, testKey(AdminKey), nil)
+
+	err := emitter.Emit(context.Background(), &UserRegisteredEvent{UserId: "zk1"})
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("err = %v, want *APIError with status 500", err)
+	}
+
+	err = emitter.Emit(context.Background(), &RawEvent{
+		Type: EventUserRegistered, Data: json.RawMessage(`["zk1"]`),
+	})
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("err = %v, want *APIError with status 400", err)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want = %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+// tamperingClient changes the body of the signed request before sending it.
+type tamperingClient struct{}
+
+func (tamperingClient) Do(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	body = bytes.Replace(body, []byte("zk1"), []byte("zk9"), 1)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return http.DefaultClient.Do(req)
+}
+
+func TestWebhookHandlerRejectsUnauthenticatedEvents(t *testing.T) {
+	h := NewWebhookHandler(testKey(AdminKey))
+	called := false
+	h.OnUserRegistered(func(ctx context.Context, e *UserRegisteredEvent) error {
+		called = true
+		return nil
+	})
+	srv := newWebhookServer(t, h)
+	event := &UserRegisteredEvent{UserId: "zk1"}
+
+	for name, emitter := range map[string]*WebhookEmitter{
+		"wrong key":     NewWebhookEmitter(srv.URL, AdminUserId, testKey(RotatedAdminKey), nil),
+		"tampered body": NewWebhookEmitter(srv.URL, AdminUserId, testKey(AdminKey), tamperingClient{}),
+		"stale date":    NewWebhookEmitter(srv.URL, AdminUserId, testKey(AdminKey), nil),
+	} {
+		if name == "stale date" {
+			h.now = func() time.Time { return time.Now().Add(time.Hour) }
+		}
+		err := emitter.Emit(context.Background(), event)
+		if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: err = %v, want *APIError with status 401", name, err)
+		}
+		h.now = time.Now
+	}
+	if called {
+		t.Error("unauthenticated event must not be dispatched")
+	}
+}
+
+type failingKey struct{}
+
+func (failingKey) AdminKey() ([]byte, error) {
+	return nil, errors.New("cannot read /etc/secrets/admin-key")
+}
+
+func TestWebhookHandlerHidesRejectionReasons(t *testing.T) {
+	var reported error
+	h := NewWebhookHandler(failingKey{},
+		WithWebhookErrorHandler(func(r *http.Request, err error) {
+			reported = err
+		}))
+	srv := newWebhookServer(t, h)
+	emitter := NewWebhookEmitter(srv.URL, AdminUserId, testKey(AdminKey), nil)
+
+	err := emitter.Emit(context.Background(), &UserRegisteredEvent{UserId: "zk1"})
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want *APIError with status 401", err)
+	}
+	if body := strings.TrimSpace(string(apiErr.Body)); body != "unauthorized" {
+		t.Errorf("body = %q, want = %q", body, "unauthorized")
+	}
+	if reported == nil || !strings.Contains(reported.Error(), "/etc/secrets/admin-key") {
+		t.Errorf("reported error = %v, want the reason of the rejection", reported)
+	}
+}
+
+func TestWebhookHandlerSecondaryKey(t *testing.T) {
+	h := NewWebhookHandler(testKey(AdminKey),
+		WithWebhookSecondaryKey(testKey(RotatedAdminKey)))
+	srv := newWebhookServer(t, h)
+	emitter := NewWebhookEmitter(srv.URL, AdminUserId, testKey(RotatedAdminKey), nil)
+
+	if err := emitter.Emit(context.Background(), &UserRegisteredEvent{UserId: "zk1"}); err != nil {
+		t.Errorf("event signed with the secondary key must be accepted, was = %v", err)
+	}
+}
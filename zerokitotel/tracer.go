@@ -0,0 +1,125 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package zerokitotel adapts OpenTelemetry to the zerokit.Tracer interface,
+// so that every ZeroKit admin API operation shows up as a client span in the
+// caller's trace.
+package zerokitotel
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/gesundheitscloud/go-zerokit-api-client"
+
+// The span attributes set in addition to the operation name.
+const (
+	EndpointKey   = attribute.Key("zerokit.endpoint")
+	StatusCodeKey = attribute.Key("http.response.status_code")
+)
+
+// Tracer implements zerokit.Tracer using an OpenTelemetry tracer provider
+// and text map propagator.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// Option configures the Tracer.
+type Option func(*Tracer)
+
+// WithTracerProvider sets the tracer provider used to create spans. The
+// global tracer provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(t *Tracer) {
+		t.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// WithPropagator sets the propagator used to inject the trace context into
+// the outgoing requests. The global text map propagator is used by default.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(t *Tracer) {
+		t.propagator = p
+	}
+}
+
+// NewTracer creates a zerokit.Tracer backed by OpenTelemetry.
+func NewTracer(opts ...Option) *Tracer {
+	t := &Tracer{
+		tracer:     otel.GetTracerProvider().Tracer(instrumentationName),
+		propagator: otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Start starts a client span for the admin API operation.
+func (t *Tracer) Start(ctx context.Context, operation,
+	endpoint string) (context.Context, zerokit.Span) {
+	ctx, span := t.tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(EndpointKey.String(endpoint)),
+	)
+	return ctx, otelSpan{span}
+}
+
+// Inject injects the trace context of ctx into the request headers.
+func (t *Tracer) Inject(ctx context.Context, header http.Header) {
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(statusCode int, err error) {
+	if statusCode != 0 {
+		s.span.SetAttributes(StatusCodeKey.Int(statusCode))
+	}
+	switch {
+	case err != nil:
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	case statusCode >= http.StatusBadRequest:
+		s.span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+	s.span.End()
+}
@@ -0,0 +1,116 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokitotel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracer() (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return NewTracer(
+		WithTracerProvider(tp),
+		WithPropagator(propagation.TraceContext{}),
+	), recorder
+}
+
+func TestSpanAttributes(t *testing.T) {
+	tracer, recorder := newTestTracer()
+
+	_, span := tracer.Start(context.Background(), "ValidateUserRegistration",
+		"/api/v4/admin/user/validate-user-registration")
+	span.End(http.StatusOK, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("number of spans = %d, want = %d", len(spans), 1)
+	}
+	s := spans[0]
+	if s.Name() != "ValidateUserRegistration" {
+		t.Errorf("span name = %s, want = %s", s.Name(), "ValidateUserRegistration")
+	}
+	if s.SpanKind() != trace.SpanKindClient {
+		t.Errorf("span kind = %v, want = %v", s.SpanKind(), trace.SpanKindClient)
+	}
+
+	attrs := map[string]interface{}{}
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	if attrs[string(EndpointKey)] != "/api/v4/admin/user/validate-user-registration" {
+		t.Errorf("%s = %v", EndpointKey, attrs[string(EndpointKey)])
+	}
+	if attrs[string(StatusCodeKey)] != int64(http.StatusOK) {
+		t.Errorf("%s = %v, want = %d",
+			StatusCodeKey, attrs[string(StatusCodeKey)], http.StatusOK)
+	}
+	if s.Status().Code != codes.Unset {
+		t.Errorf("span status = %v, want = %v", s.Status().Code, codes.Unset)
+	}
+}
+
+func TestSpanErrorStatus(t *testing.T) {
+	tracer, recorder := newTestTracer()
+
+	_, span := tracer.Start(context.Background(), "ListTresorMembers", "")
+	span.End(http.StatusUnauthorized, nil)
+	_, span = tracer.Start(context.Background(), "ListTresorMembers", "")
+	span.End(0, errors.New("connection reset"))
+
+	for _, s := range recorder.Ended() {
+		if s.Status().Code != codes.Error {
+			t.Errorf("span status = %v, want = %v", s.Status().Code, codes.Error)
+		}
+	}
+}
+
+func TestInjectTraceContext(t *testing.T) {
+	tracer, _ := newTestTracer()
+
+	ctx, span := tracer.Start(context.Background(), "InitUserRegistration", "")
+	defer span.End(http.StatusOK, nil)
+
+	header := http.Header{}
+	tracer.Inject(ctx, header)
+	if header.Get("traceparent") == "" {
+		t.Error("traceparent header is not injected")
+	}
+}
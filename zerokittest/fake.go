@@ -0,0 +1,366 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package zerokittest provides a fake zerokit.ExtendedAdminClient for the
+// tests of services using the ZeroKit admin API.
+package zerokittest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+// Call is a recorded call of an admin operation. Method is the name of the
+// operation without the Context suffix, so that ListTresorMembers and
+// ListTresorMembersContext are recorded alike.
+type Call struct {
+	Method string
+	Args   []string
+}
+
+// Fake is a zerokit.ExtendedAdminClient recording its calls. The responses
+// are programmed by setting the function of an operation; operations without
+// a function succeed, returning no tresor members and a new user
+// registration respectively. The helpers without a function, including the
+// ones taking typed ids, are answered with the functions of the operations
+// they build on, e.g. IsMember with ListTresorMembersFunc. The functions
+// must be set before the Fake is used.
+type Fake struct {
+	ListTresorMembersFunc        func(ctx context.Context, tresorId string) ([]string, error)
+	InitUserRegistrationFunc     func(ctx context.Context) (*zerokit.UserRegistrationData, error)
+	ApproveTresorCreationFunc    func(ctx context.Context, tresorId string) error
+	ApproveShareFunc             func(ctx context.Context, operationId string) error
+	ApproveKickFunc              func(ctx context.Context, operationId string) error
+	ValidateUserRegistrationFunc func(ctx context.Context, zeroKitId, sessionId,
+		sessionVerifier, validationVerifier string) error
+
+	ListTresorMembersDetailedFunc func(ctx context.Context,
+		tresorId string) (*zerokit.TresorMembers, error)
+	IsMemberFunc     func(ctx context.Context, tresorId, userId string) (bool, error)
+	ValidateUserFunc func(ctx context.Context, req zerokit.ValidateUserRegistrationRequest) error
+
+	mu            sync.Mutex
+	calls         []Call
+	registrations int
+}
+
+var _ zerokit.ExtendedAdminClient = (*Fake)(nil)
+
+// Calls returns the calls made so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls of the operation made so far, in order.
+func (f *Fake) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls made so far.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+func (f *Fake) record(method string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+}
+
+// ListTresorMembers records the call and returns the programmed response.
+func (f *Fake) ListTresorMembers(tresorId string) ([]string, error) {
+	return f.ListTresorMembersContext(context.Background(), tresorId)
+}
+
+// ListTresorMembersContext is like ListTresorMembers.
+func (f *Fake) ListTresorMembersContext(ctx context.Context,
+	tresorId string) ([]string, error) {
+	f.record("ListTresorMembers", tresorId)
+	return f.members(ctx, tresorId)
+}
+
+func (f *Fake) members(ctx context.Context, tresorId string) ([]string, error) {
+	if f.ListTresorMembersFunc != nil {
+		return f.ListTresorMembersFunc(ctx, tresorId)
+	}
+	return []string{}, nil
+}
+
+// InitUserRegistration records the call and returns the programmed
+// response. Without one, every call returns a new registration of user1,
+// user2 and so on.
+func (f *Fake) InitUserRegistration() (*zerokit.UserRegistrationData, error) {
+	return f.InitUserRegistrationContext(context.Background())
+}
+
+// InitUserRegistrationContext is like InitUserRegistration.
+func (f *Fake) InitUserRegistrationContext(
+	ctx context.Context) (*zerokit.UserRegistrationData, error) {
+	f.record("InitUserRegistration")
+	if f.InitUserRegistrationFunc != nil {
+		return f.InitUserRegistrationFunc(ctx)
+	}
+	f.mu.Lock()
+	f.registrations++
+	n := f.registrations
+	f.mu.Unlock()
+	return &zerokit.UserRegistrationData{
+		SessionId:       fmt.Sprintf("session%d", n),
+		SessionVerifier: fmt.Sprintf("verifier%d", n),
+		UserId:          fmt.Sprintf("user%d", n),
+	}, nil
+}
+
+// ApproveTresorCreation records the call and returns the programmed
+// response.
+func (f *Fake) ApproveTresorCreation(tresorId string) error {
+	return f.ApproveTresorCreationContext(context.Background(), tresorId)
+}
+
+// ApproveTresorCreationContext is like ApproveTresorCreation.
+func (f *Fake) ApproveTresorCreationContext(ctx context.Context,
+	tresorId string) error {
+	f.record("ApproveTresorCreation", tresorId)
+	if f.ApproveTresorCreationFunc != nil {
+		return f.ApproveTresorCreationFunc(ctx, tresorId)
+	}
+	return nil
+}
+
+// ApproveShare records the call and returns the programmed response.
+func (f *Fake) ApproveShare(operationId string) error {
+	return f.ApproveShareContext(context.Background(), operationId)
+}
+
+// ApproveShareContext is like ApproveShare.
+func (f *Fake) ApproveShareContext(ctx context.Context, operationId string) error {
+	f.record("ApproveShare", operationId)
+	if f.ApproveShareFunc != nil {
+		return f.ApproveShareFunc(ctx, operationId)
+	}
+	return nil
+}
+
+// ApproveKick records the call and returns the programmed response.
+func (f *Fake) ApproveKick(operationId string) error {
+	return f.ApproveKickContext(context.Background(), operationId)
+}
+
+// ApproveKickContext is like ApproveKick.
+func (f *Fake) ApproveKickContext(ctx context.Context, operationId string) error {
+	f.record("ApproveKick", operationId)
+	if f.ApproveKickFunc != nil {
+		return f.ApproveKickFunc(ctx, operationId)
+	}
+	return nil
+}
+
+// ValidateUserRegistration records the call and returns the programmed
+// response.
+func (f *Fake) ValidateUserRegistration(zeroKitId, sessionId, sessionVerifier,
+	validationVerifier string) error {
+	return f.ValidateUserRegistrationContext(context.Background(), zeroKitId,
+		sessionId, sessionVerifier, validationVerifier)
+}
+
+// ValidateUserRegistrationContext is like ValidateUserRegistration.
+func (f *Fake) ValidateUserRegistrationContext(ctx context.Context, zeroKitId,
+	sessionId, sessionVerifier, validationVerifier string) error {
+	f.record("ValidateUserRegistration", zeroKitId, sessionId, sessionVerifier,
+		validationVerifier)
+	if f.ValidateUserRegistrationFunc != nil {
+		return f.ValidateUserRegistrationFunc(ctx, zeroKitId, sessionId,
+			sessionVerifier, validationVerifier)
+	}
+	return nil
+}
+
+// ListTresorMembersDetailed records the call and returns the programmed
+// response. Without one, the members are those of ListTresorMembersFunc.
+func (f *Fake) ListTresorMembersDetailed(tresorId string) (*zerokit.TresorMembers, error) {
+	return f.ListTresorMembersDetailedContext(context.Background(), tresorId)
+}
+
+// ListTresorMembersDetailedContext is like ListTresorMembersDetailed.
+func (f *Fake) ListTresorMembersDetailedContext(ctx context.Context,
+	tresorId string) (*zerokit.TresorMembers, error) {
+	f.record("ListTresorMembersDetailed", tresorId)
+	if f.ListTresorMembersDetailedFunc != nil {
+		return f.ListTresorMembersDetailedFunc(ctx, tresorId)
+	}
+	members, err := f.members(ctx, tresorId)
+	if err != nil {
+		return nil, err
+	}
+	return &zerokit.TresorMembers{TresorId: tresorId, Members: members}, nil
+}
+
+// IsMember records the call and returns the programmed response. Without
+// one, it checks the members returned by ListTresorMembersFunc.
+func (f *Fake) IsMember(tresorId, userId string) (bool, error) {
+	return f.IsMemberContext(context.Background(), tresorId, userId)
+}
+
+// IsMemberContext is like IsMember.
+func (f *Fake) IsMemberContext(ctx context.Context, tresorId,
+	userId string) (bool, error) {
+	f.record("IsMember", tresorId, userId)
+	if f.IsMemberFunc != nil {
+		return f.IsMemberFunc(ctx, tresorId, userId)
+	}
+	members, err := f.members(ctx, tresorId)
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if member == userId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ValidateUser records the call and returns the programmed response.
+// Without one, the request is validated like by the client and passed to
+// ValidateUserRegistrationFunc.
+func (f *Fake) ValidateUser(req zerokit.ValidateUserRegistrationRequest) error {
+	return f.ValidateUserContext(context.Background(), req)
+}
+
+// ValidateUserContext is like ValidateUser.
+func (f *Fake) ValidateUserContext(ctx context.Context,
+	req zerokit.ValidateUserRegistrationRequest) error {
+	f.record("ValidateUser", string(req.UserId), string(req.RegSessionId),
+		req.RegSessionVerifier, req.RegValidationVerifier)
+	if f.ValidateUserFunc != nil {
+		return f.ValidateUserFunc(ctx, req)
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	if f.ValidateUserRegistrationFunc != nil {
+		return f.ValidateUserRegistrationFunc(ctx, string(req.UserId),
+			string(req.RegSessionId), req.RegSessionVerifier, req.RegValidationVerifier)
+	}
+	return nil
+}
+
+// ListMembers records the call and returns the members of
+// ListTresorMembersFunc, after validating the tresor id like the client.
+func (f *Fake) ListMembers(tresorId zerokit.TresorId) ([]zerokit.UserId, error) {
+	return f.ListMembersContext(context.Background(), tresorId)
+}
+
+// ListMembersContext is like ListMembers.
+func (f *Fake) ListMembersContext(ctx context.Context,
+	tresorId zerokit.TresorId) ([]zerokit.UserId, error) {
+	f.record("ListMembers", string(tresorId))
+	if err := tresorId.Validate(); err != nil {
+		return nil, err
+	}
+	members, err := f.members(ctx, string(tresorId))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]zerokit.UserId, len(members))
+	for i, member := range members {
+		ids[i] = zerokit.UserId(member)
+	}
+	return ids, nil
+}
+
+// ApproveTresor records the call and returns the response of
+// ApproveTresorCreationFunc, after validating the tresor id like the client.
+func (f *Fake) ApproveTresor(tresorId zerokit.TresorId) error {
+	return f.ApproveTresorContext(context.Background(), tresorId)
+}
+
+// ApproveTresorContext is like ApproveTresor.
+func (f *Fake) ApproveTresorContext(ctx context.Context, tresorId zerokit.TresorId) error {
+	f.record("ApproveTresor", string(tresorId))
+	if err := tresorId.Validate(); err != nil {
+		return err
+	}
+	if f.ApproveTresorCreationFunc != nil {
+		return f.ApproveTresorCreationFunc(ctx, string(tresorId))
+	}
+	return nil
+}
+
+// ApproveShareOperation records the call and returns the response of
+// ApproveShareFunc, after validating the operation id like the client.
+func (f *Fake) ApproveShareOperation(operationId zerokit.OperationId) error {
+	return f.ApproveShareOperationContext(context.Background(), operationId)
+}
+
+// ApproveShareOperationContext is like ApproveShareOperation.
+func (f *Fake) ApproveShareOperationContext(ctx context.Context,
+	operationId zerokit.OperationId) error {
+	f.record("ApproveShareOperation", string(operationId))
+	if err := operationId.Validate(); err != nil {
+		return err
+	}
+	if f.ApproveShareFunc != nil {
+		return f.ApproveShareFunc(ctx, string(operationId))
+	}
+	return nil
+}
+
+// ApproveKickOperation records the call and returns the response of
+// ApproveKickFunc, after validating the operation id like the client.
+func (f *Fake) ApproveKickOperation(operationId zerokit.OperationId) error {
+	return f.ApproveKickOperationContext(context.Background(), operationId)
+}
+
+// ApproveKickOperationContext is like ApproveKickOperation.
+func (f *Fake) ApproveKickOperationContext(ctx context.Context,
+	operationId zerokit.OperationId) error {
+	f.record("ApproveKickOperation", string(operationId))
+	if err := operationId.Validate(); err != nil {
+		return err
+	}
+	if f.ApproveKickFunc != nil {
+		return f.ApproveKickFunc(ctx, string(operationId))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+//BSD 3-Clause License
+//
+//Copyright (c) 2017, Hasso-Plattner-Institut für Softwaresystemtechnik GmbH
+//All rights reserved.
+//
+//Redistribution and use in source and binary forms, with or without
+//modification, are permitted provided that the following conditions are met:
+//
+//* Redistributions of source code must retain the above copyright notice, this
+//list of conditions and the following disclaimer.
+//
+//* Redistributions in binary form must reproduce the above copyright notice,
+//this list of conditions and the following disclaimer in the documentation
+//and/or other materials provided with the distribution.
+//
+//* Neither the name of the copyright holder nor the names of its
+//contributors may be used to endorse or promote products derived from
+//this software without specific prior written permission.
+//
+//THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+//AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+//IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+//DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+//FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+//DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+//SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+//CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+//OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package zerokittest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gesundheitscloud/go-zerokit-api-client"
+)
+
+// revoke is the kind of consumer code the Fake stands in for.
+func revoke(client zerokit.AdminClient, tresorId, userId string) (bool, error) {
+	members, err := client.ListTresorMembers(tresorId)
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if member == userId {
+			return true, client.ApproveKick("kick-" + userId)
+		}
+	}
+	return false, nil
+}
+
+func TestFake(t *testing.T) {
+	f := &Fake{
+		ListTresorMembersFunc: func(ctx context.Context, tresorId string) ([]string, error) {
+			return []string{"zk1", "zk2"}, nil
+		},
+	}
+
+	revoked, err := revoke(f, "tresor1", "zk2")
+	if err != nil || !revoked {
+		t.Fatalf("expected zk2 to be revoked, got %v, %v", revoked, err)
+	}
+
+	want := []Call{
+		{Method: "ListTresorMembers", Args: []string{"tresor1"}},
+		{Method: "ApproveKick", Args: []string{"kick-zk2"}},
+	}
+	if calls := f.Calls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want = %v", calls, want)
+	}
+	if calls := f.CallsTo("ApproveKick"); len(calls) != 1 {
+		t.Errorf("expected one call to ApproveKick, got %v", calls)
+	}
+
+	f.Reset()
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("expected no calls after reset, got %v", calls)
+	}
+}
+
+func TestFakeError(t *testing.T) {
+	apiErr := &zerokit.APIError{StatusCode: 404, ErrorCode: "TresorNotExists"}
+	f := &Fake{
+		ListTresorMembersFunc: func(ctx context.Context, tresorId string) ([]string, error) {
+			return nil, apiErr
+		},
+	}
+
+	_, err := revoke(f, "tresor1", "zk2")
+	if !errors.Is(err, apiErr) {
+		t.Errorf("expected the programmed error, got %v", err)
+	}
+	if calls := f.CallsTo("ApproveKick"); len(calls) != 0 {
+		t.Errorf("expected no call to ApproveKick, got %v", calls)
+	}
+}
+
+func TestFakeDefaults(t *testing.T) {
+	f := &Fake{}
+	first, _ := f.InitUserRegistration()
+	second, _ := f.InitUserRegistrationContext(context.Background())
+	if first.UserId == second.UserId || first.SessionId == second.SessionId {
+		t.Errorf("expected distinct registrations, got %+v and %+v", first, second)
+	}
+	if members, err := f.ListTresorMembers("tresor1"); err != nil || len(members) != 0 {
+		t.Errorf("expected no members, got %v, %v", members, err)
+	}
+	if err := f.ValidateUserRegistration(first.UserId, first.SessionId,
+		first.SessionVerifier, "validation"); err != nil {
+		t.Error(err)
+	}
+	if calls := f.CallsTo("InitUserRegistration"); len(calls) != 2 {
+		t.Errorf("expected both registrations to be recorded, got %v", calls)
+	}
+}
+
+func TestFakeHelpers(t *testing.T) {
+	var validated []string
+	f := &Fake{
+		ListTresorMembersFunc: func(ctx context.Context, tresorId string) ([]string, error) {
+			return []string{"zk1"}, nil
+		},
+		ValidateUserRegistrationFunc: func(ctx context.Context, zeroKitId, sessionId,
+			sessionVerifier, validationVerifier string) error {
+			validated = append(validated, zeroKitId)
+			return nil
+		},
+	}
+
+	if member, err := f.IsMember("tresor1", "zk1"); err != nil || !member {
+		t.Errorf("expected zk1 to be a member, got %v, %v", member, err)
+	}
+	if member, _ := f.IsMember("tresor1", "zk2"); member {
+		t.Error("expected zk2 not to be a member")
+	}
+	detailed, err := f.ListTresorMembersDetailed("tresor1")
+	if err != nil || detailed.TresorId != "tresor1" || !detailed.Contains("zk1") {
+		t.Errorf("unexpected detailed members %+v, %v", detailed, err)
+	}
+
+	req := zerokit.ValidateUserRegistrationRequest{
+		UserId:                "0123456789abcdefghijklmn",
+		RegSessionId:          "session1",
+		RegSessionVerifier:    "verifier1",
+		RegValidationVerifier: "validation1",
+	}
+	if err := f.ValidateUser(req); err != nil {
+		t.Error(err)
+	}
+	req.UserId = "invalid"
+	if err := f.ValidateUser(req); !errors.Is(err, zerokit.ErrInvalidId) {
+		t.Errorf("err = %v, want = %v", err, zerokit.ErrInvalidId)
+	}
+	if len(validated) != 1 {
+		t.Errorf("expected one validation to be passed on, got %v", validated)
+	}
+	if calls := f.CallsTo("ValidateUser"); len(calls) != 2 {
+		t.Errorf("expected both validations to be recorded, got %v", calls)
+	}
+}
+
+func TestFakeTypedOperations(t *testing.T) {
+	var kicked []string
+	f := &Fake{
+		ApproveKickFunc: func(ctx context.Context, operationId string) error {
+			kicked = append(kicked, operationId)
+			return nil
+		},
+	}
+
+	if err := f.ApproveKickOperation("kick1"); err != nil {
+		t.Error(err)
+	}
+	if err := f.ApproveTresor("invalid"); !errors.Is(err, zerokit.ErrInvalidId) {
+		t.Errorf("err = %v, want = %v", err, zerokit.ErrInvalidId)
+	}
+	if members, err := f.ListMembers("0000t8k47k21ozyqlxtq1bd8"); err != nil || len(members) != 0 {
+		t.Errorf("expected no members, got %v, %v", members, err)
+	}
+	if !reflect.DeepEqual(kicked, []string{"kick1"}) {
+		t.Errorf("kicked = %v, want = [kick1]", kicked)
+	}
+	if calls := f.CallsTo("ApproveTresor"); len(calls) != 1 {
+		t.Errorf("expected the invalid approval to be recorded, got %v", calls)
+	}
+}